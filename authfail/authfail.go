@@ -0,0 +1,143 @@
+// Package authfail 按客户端 IP 和用户名统计认证失败，滑动窗口内失败次数达到阈值时产生告警
+package authfail
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+)
+
+// maxKeys 最多跟踪的客户端和用户数，超过时清理窗口外的记录
+const maxKeys = 100000
+
+// Tracker 认证失败的滑动窗口统计，各协议的解析协程共用
+type Tracker struct {
+	limit  int
+	window time.Duration
+	mutex  sync.Mutex
+	keys   map[string]*failures
+}
+
+// failures 一个客户端或客户端+用户的最近失败
+type failures struct {
+	attempts []attempt // 最近 limit 次失败，按时间先后
+	alerted  time.Time // 上次告警的抓包时间，一个窗口内只告警一次
+}
+
+type attempt struct {
+	ts   time.Time
+	user string
+}
+
+var tracker *Tracker
+
+// SetLimit 窗口内认证失败达到 limit 次时告警，limit 为 0 时不统计
+func SetLimit(limit int, window time.Duration) {
+	if limit <= 0 || window <= 0 {
+		tracker = nil
+		return
+	}
+	tracker = &Tracker{limit: limit, window: window, keys: make(map[string]*failures)}
+}
+
+// Observe 记录一次认证失败的事件，返回需要输出的告警
+func Observe(e *event.Event) []*event.Event {
+	if tracker == nil {
+		return nil
+	}
+	return tracker.observe(e)
+}
+
+func (t *Tracker) observe(e *event.Event) []*event.Event {
+	client, _, err := net.SplitHostPort(e.ClientAddr)
+	if err != nil {
+		client = e.ClientAddr
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.keys) >= maxKeys {
+		t.sweep(e.Timestamp)
+	}
+
+	// 同一用户的密码猜测，以及同一客户端尝试多个用户名(撞库)
+	users := []string{""}
+	if e.User != "" {
+		users = append(users, e.User)
+	}
+	var alerts []*event.Event
+	for _, user := range users {
+		key := e.Protocol + "|" + client + "|" + user
+		f, ok := t.keys[key]
+		if !ok {
+			f = &failures{}
+			t.keys[key] = f
+		}
+		users := f.add(attempt{ts: e.Timestamp, user: e.User}, t.limit, t.window)
+		count := 0
+		for _, n := range users {
+			count += n
+		}
+		if _, anonymous := users[""]; user == "" && len(users) == 1 && !anonymous {
+			continue // 只有一个用户时由该用户的统计告警
+		}
+		if count >= t.limit && (f.alerted.IsZero() || e.Timestamp.Sub(f.alerted) >= t.window) {
+			f.alerted = e.Timestamp
+			alerts = append(alerts, t.alert(e, client, user, count, users))
+		}
+	}
+	return alerts
+}
+
+// add 记录一次失败，返回窗口内各用户的失败次数
+func (f *failures) add(a attempt, limit int, window time.Duration) map[string]int {
+	f.attempts = append(f.attempts, a)
+	if len(f.attempts) > limit {
+		f.attempts = f.attempts[len(f.attempts)-limit:]
+	}
+	users := make(map[string]int)
+	for _, prev := range f.attempts {
+		if a.ts.Sub(prev.ts) < window {
+			users[prev.user]++
+		}
+	}
+	return users
+}
+
+// sweep 删除最后一次失败已在窗口外的记录
+func (t *Tracker) sweep(now time.Time) {
+	for key, f := range t.keys {
+		if now.Sub(f.attempts[len(f.attempts)-1].ts) >= t.window {
+			delete(t.keys, key)
+		}
+	}
+}
+
+func (t *Tracker) alert(e *event.Event, client, user string, count int, users map[string]int) *event.Event {
+	failures := &event.AuthFailures{Client: client, User: user, Count: count, Window: t.window}
+	statement := fmt.Sprintf("Failed logins:%d in %s, client:%s,user:%s", count, t.window, client, user)
+	if user == "" {
+		for u := range users {
+			failures.Users = append(failures.Users, u)
+		}
+		sort.Strings(failures.Users)
+		statement = fmt.Sprintf("Failed logins:%d in %s, client:%s,users:%d", count, t.window, client, len(users))
+	}
+	return &event.Event{
+		Protocol:     e.Protocol,
+		ClientAddr:   e.ClientAddr,
+		ServerAddr:   e.ServerAddr,
+		ConnID:       e.ConnID,
+		User:         user,
+		Command:      "AUTH_FAILURE",
+		Statement:    statement,
+		Timestamp:    e.Timestamp,
+		Result:       event.ResultError,
+		AuthFailures: failures,
+		Alert:        event.AlertBruteForce,
+	}
+}
@@ -0,0 +1,43 @@
+package authfail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+)
+
+func TestObserve(t *testing.T) {
+	SetLimit(3, time.Minute)
+	defer SetLimit(0, 0)
+
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	fail := func(user string, at time.Duration) []*event.Event {
+		return Observe(&event.Event{Protocol: event.ProtocolMySQL, ClientAddr: "10.0.0.2:5000", User: user, Timestamp: t0.Add(at)})
+	}
+
+	// 窗口外的失败不计数
+	fail("root", 0)
+	if alerts := fail("root", 2*time.Minute); len(alerts) != 0 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+	fail("root", 2*time.Minute+time.Second)
+	alerts := fail("root", 2*time.Minute+2*time.Second)
+	if len(alerts) != 1 || alerts[0].Alert != event.AlertBruteForce || alerts[0].AuthFailures.User != "root" ||
+		alerts[0].AuthFailures.Count != 3 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+	// 同一窗口内只告警一次
+	if alerts := fail("root", 2*time.Minute+3*time.Second); len(alerts) != 0 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+
+	// 同一客户端尝试多个用户
+	fail("admin", 10*time.Minute)
+	fail("test", 10*time.Minute+time.Second)
+	alerts = fail("guest", 10*time.Minute+2*time.Second)
+	if len(alerts) != 1 || alerts[0].AuthFailures.User != "" || alerts[0].AuthFailures.Client != "10.0.0.2" ||
+		len(alerts[0].AuthFailures.Users) != 3 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+}
@@ -69,8 +69,3 @@ func TestCreateUser(t *testing.T) {
 		t.Error(err)
 	}
 }
-
-func TestExplainSQL(t *testing.T) {
-	sql := "SELECT * FROM `user` WHERE name = ? AND phone = ? ORDER BY `user`.`name` LIMIT ?"
-	t.Log(ExplainSQL(sql, nil, `'`, "jackson", 189593868))
-}
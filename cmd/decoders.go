@@ -0,0 +1,8 @@
+package cmd
+
+// 协议解析器在各自包的 init 中注册到 decoder 包，新增协议只需在这里导入
+import (
+	_ "github.com/JacksonChan-X/sql-sniffer/mongo"
+	_ "github.com/JacksonChan-X/sql-sniffer/mysql"
+	_ "github.com/JacksonChan-X/sql-sniffer/redis"
+)
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/decoder"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// pcapng 文件以 Section Header Block 开头
+const pcapngMagic = 0x0A0D0D0A
+
+// packetReader pcap 与 pcapng 读取器的公共接口
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// capture 离线回放时一个协议端口对应的重组器
+type capture struct {
+	name      string
+	port      string
+	factory   decoder.StreamFactory
+	assembler *tcpassembly.Assembler
+	packets   int
+	streams   int
+}
+
+// New 统计流数量后交给协议自己的 StreamFactory
+func (c *capture) New(net, transport gopacket.Flow) tcpassembly.Stream {
+	c.streams++
+	return c.factory.New(net, transport)
+}
+
+// ReplayPacket 读取离线抓包文件，走与 FetchPacket 相同的重组与解析流程，
+// 文件读完后刷新所有重组器并等待解析协程退出
+func ReplayPacket(ctx context.Context, file string, targets []target) error {
+	reader, closer, err := openPacketReader(file)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	var all []*capture
+	captures := make(map[string][]*capture)
+	for _, t := range targets {
+		for _, port := range t.ports {
+			c := &capture{name: t.decoder.Name(), port: port, factory: newStreamFactory(port, t.decoder, true)}
+			c.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(c))
+			captures[port] = append(captures[port], c)
+			all = append(all, c)
+		}
+	}
+
+	var (
+		total, tcpTotal int
+		first, last     time.Time
+		lastFlush       time.Time
+		interrupted     bool
+		begin           = time.Now()
+		packetSource    = gopacket.NewPacketSource(reader, reader.LinkType())
+	)
+
+Loop:
+	for {
+		select {
+		case <-ctx.Done():
+			interrupted = true
+			break Loop
+		default:
+		}
+
+		pkt, err := packetSource.NextPacket()
+		if err != nil {
+			if err == io.EOF {
+				break Loop
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Warn(fmt.Sprintf("抓包文件被截断: %v", err))
+				break Loop
+			}
+			return err
+		}
+		total++
+
+		ts := pkt.Metadata().Timestamp
+		if first.IsZero() {
+			first, lastFlush = ts, ts
+		}
+		last = ts
+
+		if pkt.NetworkLayer() == nil || pkt.TransportLayer() == nil ||
+			pkt.TransportLayer().LayerType() != layers.LayerTypeTCP {
+			continue
+		}
+		tcpTotal++
+		tcp := pkt.TransportLayer().(*layers.TCP)
+
+		src, dst := strconv.Itoa(int(tcp.SrcPort)), strconv.Itoa(int(tcp.DstPort))
+		for _, c := range captures[src] {
+			c.packets++
+			c.assembler.AssembleWithTimestamp(pkt.NetworkLayer().NetworkFlow(), tcp, ts)
+		}
+		if dst != src {
+			for _, c := range captures[dst] {
+				c.packets++
+				c.assembler.AssembleWithTimestamp(pkt.NetworkLayer().NetworkFlow(), tcp, ts)
+			}
+		}
+
+		// 按抓包时间而不是墙上时间清理长时间无数据的流
+		if ts.Sub(lastFlush) > time.Second*10 {
+			for _, c := range all {
+				c.assembler.FlushOlderThan(ts.Add(time.Minute * -2))
+			}
+			lastFlush = ts
+		}
+	}
+
+	for _, c := range all {
+		c.assembler.FlushAll()
+	}
+	for _, c := range all {
+		c.factory.Wait()
+	}
+
+	logger.Info(fmt.Sprintf("回放结束: 文件:%s, 数据包:%d, TCP包:%d, 抓包时间:%s ~ %s, 耗时:%s, 中断:%v",
+		file, total, tcpTotal, first.Format(time.DateTime), last.Format(time.DateTime),
+		time.Since(begin).Round(time.Millisecond), interrupted,
+	))
+	for _, c := range all {
+		logger.Info(fmt.Sprintf("回放统计: %s:%s, 数据包:%d, 流:%d", c.name, c.port, c.packets, c.streams))
+	}
+	return nil
+}
+
+// openPacketReader 打开 pcap 或 pcapng 文件，file 为 - 时读取标准输入
+func openPacketReader(file string) (packetReader, io.Closer, error) {
+	var f *os.File
+	if file == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("读取文件头失败: %v", err)
+	}
+
+	// Section Header Block 的类型字段是回文，大小端一致
+	if binary.LittleEndian.Uint32(magic) == pcapngMagic {
+		ng, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return ng, f, nil
+	}
+
+	pr, err := pcapgo.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return pr, f, nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/sirupsen/logrus"
+)
+
+type recordSink struct {
+	mu     sync.Mutex
+	events []*event.Event
+}
+
+func (s *recordSink) Emit(e *event.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+// testdata/replay.pcap: 一个 MySQL 连接 (SELECT 1 成功、UPDATE 语法错误) 和一个 Redis 连接 (SET、GET)
+func TestReplayPacket(t *testing.T) {
+	defer func(l *logrus.Logger, s event.Sink, p []string) { logger, sink, protos = l, s, p }(logger, sink, protos)
+	logger = logrus.New()
+	logger.SetOutput(io.Discard)
+	rec := &recordSink{}
+	sink = rec
+	protos = []string{"mysql", "redis"}
+
+	targets, err := resolveTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplayPacket(context.Background(), "testdata/replay.pcap", targets); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string][]string)
+	for _, e := range rec.events {
+		// 跳过不属于连接的错误汇总
+		if len(e.ClientAddr) == 0 {
+			continue
+		}
+		got[e.Protocol] = append(got[e.Protocol], e.Text()+" "+e.Result)
+	}
+	want := map[string][]string{
+		event.ProtocolMySQL: {"SELECT 1 ok", "UPDATE t SET a=1 error"},
+		event.ProtocolRedis: {"SET k v ", "GET k "},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -2,15 +2,30 @@ package cmd
 
 import (
 	"os"
+	"strings"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/decoder"
+	"github.com/JacksonChan-X/sql-sniffer/event"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
-	interfaces, mysqlPorts, mongoPorts, redisPorts string
-	logger                                         *logrus.Logger
-	debug                                          bool
+	interfaces string
+	protos     []string
+	ports      = make(map[string]*string) // 各协议的 --name_port
+	readFile   string
+	outputs    []string
+	rotateSize int64
+	rotateAge  time.Duration
+	tlsKeyLog  string
+	authLimit  int
+	authWindow time.Duration
+	logger     *logrus.Logger
+	sink       event.Sink
+	debug      bool
 )
 
 var rootCmd = &cobra.Command{
@@ -18,8 +33,12 @@ var rootCmd = &cobra.Command{
 	Short: "MySQL、MongoDB和Redis流量嗅探工具",
 	Long: `mysql-sniffer是一个网络流量嗅探工具，
 可以捕获并分析MySQL、MongoDB和Redis的网络流量。`,
-	Example: "sql-sniffer -i eth0 -mysql_port 3306,3307 -mongo_port 27017",
-	Run:     sniffer,
+	Example: `sql-sniffer -i eth0 -mysql_port 3306,3307 -mongo_port 27017
+sql-sniffer -i eth0 --proto mysql=3306,3307 --proto redis=6379
+sql-sniffer --read capture.pcapng --mysql_port 3306
+sql-sniffer --read capture.pcapng --tls_keylog /tmp/sslkeylog.txt
+tcpdump -i eth0 -w - port 3306 | sql-sniffer --read -`,
+	Run: sniffer,
 }
 
 // Execute 添加所有子命令到根命令并设置标志。
@@ -34,8 +53,19 @@ func Execute() {
 func init() {
 	// 全局标志
 	rootCmd.PersistentFlags().StringVarP(&interfaces, "interfaces", "i", "", "要监听的网络接口，逗号分隔")
-	rootCmd.PersistentFlags().StringVar(&mysqlPorts, "mysql_port", "3306", "MySQL端口，逗号分隔")
-	rootCmd.PersistentFlags().StringVar(&mongoPorts, "mongo_port", "27017", "MongoDB端口，逗号分隔")
-	rootCmd.PersistentFlags().StringVar(&redisPorts, "redis_port", "6379", "Redis端口，逗号分隔")
+	rootCmd.PersistentFlags().StringArrayVar(&protos, "proto", nil, "要解析的协议和端口，可重复指定，如 mysql=3306,3307，指定后忽略 --name_port")
+
+	// 协议标志，由各协议注册
+	for _, d := range decoder.All() {
+		ports[d.Name()] = rootCmd.PersistentFlags().String(d.Name()+"_port", strings.Join(d.DefaultPorts(), ","), d.Name()+"端口，逗号分隔")
+		d.Flags(rootCmd.PersistentFlags())
+	}
+	rootCmd.PersistentFlags().StringVarP(&readFile, "read", "r", "", "读取离线抓包文件(pcap/pcapng)，- 表示标准输入")
+	rootCmd.PersistentFlags().StringArrayVarP(&outputs, "output", "o", []string{"log"}, "输出方式，可重复指定: log 或 jsonl:/path/file.jsonl")
+	rootCmd.PersistentFlags().Int64Var(&rotateSize, "rotate_size", 100, "jsonl 文件切分大小(MB)，0 表示不按大小切分")
+	rootCmd.PersistentFlags().DurationVar(&rotateAge, "rotate_age", 24*time.Hour, "jsonl 文件切分间隔，0 表示不按时间切分")
+	rootCmd.PersistentFlags().StringVar(&tlsKeyLog, "tls_keylog", "", "NSS 格式的 TLS 密钥日志(SSLKEYLOGFILE)，用于解密 TLS 1.2/1.3 流量")
+	rootCmd.PersistentFlags().IntVar(&authLimit, "auth_fail_limit", 10, "同一客户端(或客户端+用户)在 --auth_fail_window 内认证失败达到该次数时输出 brute_force 告警，0 表示不统计")
+	rootCmd.PersistentFlags().DurationVar(&authWindow, "auth_fail_window", time.Minute, "统计认证失败的滑动窗口")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "启用调试模式")
 }
@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/JacksonChan-X/sql-sniffer/authfail"
+	"github.com/JacksonChan-X/sql-sniffer/decoder"
+	"github.com/JacksonChan-X/sql-sniffer/event"
 	"github.com/JacksonChan-X/sql-sniffer/helper"
-	"github.com/JacksonChan-X/sql-sniffer/mongo"
-	"github.com/JacksonChan-X/sql-sniffer/mysql"
-	"github.com/JacksonChan-X/sql-sniffer/redis"
 	"github.com/JacksonChan-X/sql-sniffer/server"
+	"github.com/JacksonChan-X/sql-sniffer/tlsdecrypt"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -26,19 +28,130 @@ const (
 	maxPacketSize = 1600
 )
 
-const (
-	MYSQL = "mysql"
-	MONGO = "mongo"
-	REDIS = "redis"
-)
+// target 一种协议及其要监听的端口
+type target struct {
+	decoder decoder.Decoder
+	ports   []string
+}
+
+// resolveTargets 优先使用 --proto name=ports，未指定时使用各协议的 --name_port
+func resolveTargets() ([]target, error) {
+	var targets []target
+	if len(protos) == 0 {
+		for _, d := range decoder.All() {
+			list, err := splitPorts(*ports[d.Name()])
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, target{decoder: d, ports: list})
+		}
+		return targets, nil
+	}
+
+	index := make(map[string]int)
+	for _, proto := range protos {
+		name, value, _ := strings.Cut(proto, "=")
+		d, ok := decoder.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("未知的协议: %s", name)
+		}
+		list, err := splitPorts(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			list = d.DefaultPorts()
+		}
+		if i, ok := index[name]; ok {
+			targets[i].ports = append(targets[i].ports, list...)
+			continue
+		}
+		index[name] = len(targets)
+		targets = append(targets, target{decoder: d, ports: list})
+	}
+	return targets, nil
+}
+
+// splitPorts 解析逗号分隔的端口，忽略空项
+func splitPorts(value string) ([]string, error) {
+	var list []string
+	for _, port := range strings.Split(value, ",") {
+		port = strings.TrimSpace(port)
+		if len(port) == 0 {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("无效的端口: %s", port)
+		}
+		list = append(list, port)
+	}
+	return list, nil
+}
+
+// newSink 根据 --output 创建输出，格式为 log 或 jsonl:/path/file.jsonl
+func newSink(outputs []string) (event.MultiSink, error) {
+	var sinks event.MultiSink
+	for _, output := range outputs {
+		typ, path, _ := strings.Cut(output, ":")
+		switch typ {
+		case "log":
+			sinks = append(sinks, event.NewLogSink(logger))
+		case "jsonl":
+			if len(path) == 0 {
+				sinks.Close()
+				return nil, fmt.Errorf("jsonl 输出缺少文件路径: %s", output)
+			}
+			s, err := event.NewJSONLSink(path, rotateSize<<20, rotateAge, logger)
+			if err != nil {
+				sinks.Close()
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		default:
+			sinks.Close()
+			return nil, fmt.Errorf("未知的输出方式: %s", output)
+		}
+	}
+	return sinks, nil
+}
+
+func newStreamFactory(port string, d decoder.Decoder, replay bool) decoder.StreamFactory {
+	return d.NewFactory(decoder.Options{Port: port, Logger: logger, Sink: sink, Replay: replay})
+}
 
 func sniffer(cmd *cobra.Command, args []string) {
 	// 初始化日志
 	logger = server.NewLogger(debug)
+	sinks, err := newSink(outputs)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("初始化输出失败: %v", err))
+	}
+	defer sinks.Close()
+	sink = sinks
+
+	targets, err := resolveTargets()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if len(tlsKeyLog) != 0 {
+		if err := tlsdecrypt.LoadKeyLog(tlsKeyLog); err != nil {
+			logger.Fatal(fmt.Sprintf("读取 TLS 密钥日志失败: %v", err))
+		}
+	}
+	authfail.SetLimit(authLimit, authWindow)
+
+	// 离线回放模式：读完文件即退出
+	if len(readFile) != 0 {
+		ctx, resetSignal := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+		defer resetSignal()
+
+		if err := ReplayPacket(ctx, readFile, targets); err != nil {
+			logger.Fatal(fmt.Sprintf("回放抓包文件失败: %v", err))
+		}
+		return
+	}
 
-	mysqlPortList := strings.Split(mysqlPorts, ",")
-	mongoPortList := strings.Split(mongoPorts, ",")
-	redisPortList := strings.Split(redisPorts, ",")
 	interList, err := helper.GetAllInterfaces()
 	if err != nil {
 		logger.Fatal(fmt.Sprintf("获取网卡失败: %v", err))
@@ -51,37 +164,14 @@ func sniffer(cmd *cobra.Command, args []string) {
 	eg, ctx := errgroup.WithContext(ctx)
 
 	for _, inter := range interList {
-		for _, mysqlPort := range mysqlPortList {
-			if len(mysqlPort) == 0 {
-				continue
-			}
-			i, p := inter, mysqlPort
-			eg.Go(func() error {
-				FetchPacket(ctx, i, p, MYSQL)
-				return nil
-			})
-		}
-
-		for _, mongoPort := range mongoPortList {
-			if len(mongoPort) == 0 {
-				continue
-			}
-			i, p := inter, mongoPort
-			eg.Go(func() error {
-				FetchPacket(ctx, i, p, MONGO)
-				return nil
-			})
-		}
-
-		for _, redisPort := range redisPortList {
-			if len(redisPort) == 0 {
-				continue
+		for _, t := range targets {
+			for _, port := range t.ports {
+				i, p, d := inter, port, t.decoder
+				eg.Go(func() error {
+					FetchPacket(ctx, i, p, d)
+					return nil
+				})
 			}
-			i, p := inter, redisPort
-			eg.Go(func() error {
-				FetchPacket(ctx, i, p, REDIS)
-				return nil
-			})
 		}
 	}
 
@@ -91,7 +181,7 @@ func sniffer(cmd *cobra.Command, args []string) {
 	resetSignal()
 }
 
-func FetchPacket(ctx context.Context, inter, port, typ string) {
+func FetchPacket(ctx context.Context, inter, port string, d decoder.Decoder) {
 	handle, err := pcap.OpenLive(inter, maxPacketSize, true, time.Second)
 	if err != nil {
 		logger.Fatal(err)
@@ -109,16 +199,8 @@ func FetchPacket(ctx context.Context, inter, port, typ string) {
 		return
 	}
 
-	var streamFactory tcpassembly.StreamFactory
-	switch typ {
-	case MYSQL:
-		streamFactory = &mysql.MysqlStreamFactory{Logger: logger, Port: port}
-	case MONGO:
-		streamFactory = &mongo.MongoDBStreamFactory{Logger: logger, Port: port}
-	case REDIS:
-		streamFactory = &redis.RedisStreamFactory{Logger: logger, Port: port}
-	}
-	streamPool := tcpassembly.NewStreamPool(streamFactory)
+	factory := newStreamFactory(port, d, false)
+	streamPool := tcpassembly.NewStreamPool(factory)
 	assembler := tcpassembly.NewAssembler(streamPool)
 
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
@@ -127,7 +209,9 @@ func FetchPacket(ctx context.Context, inter, port, typ string) {
 	for {
 		select {
 		case <-ctx.Done():
-			// logger.Info("context done")
+			// 与回放结束时相同，结束所有流并等待解析协程退出，Wait 会输出最后一个周期的错误汇总
+			assembler.FlushAll()
+			factory.Wait()
 			return
 		case pkt := <-packetSource.Packets():
 			if pkt.NetworkLayer() == nil || pkt.TransportLayer() == nil ||
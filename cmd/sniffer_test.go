@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitPorts(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  []string
+		err   bool
+	}{
+		{"3306", []string{"3306"}, false},
+		{" 3306, ,3307 ", []string{"3306", "3307"}, false},
+		{"", nil, false},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"3306,abc", nil, true},
+	} {
+		got, err := splitPorts(c.value)
+		if (err != nil) != c.err || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitPorts(%q) = %v, %v", c.value, got, err)
+		}
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	defer func(saved []string) { protos = saved }(protos)
+	for _, c := range []struct {
+		protos []string
+		want   string // name=ports 以空格分隔
+		err    bool
+	}{
+		{[]string{"mysql=3306,3307", "redis"}, "mysql=3306,3307 redis=6379", false},
+		// 重复的协议合并端口
+		{[]string{"mysql=3306", "mysql=3307"}, "mysql=3306,3307", false},
+		// 未指定端口时使用默认端口
+		{[]string{"mysql="}, "mysql=3306", false},
+		{[]string{"mysql=abc"}, "", true},
+		{[]string{"postgres=5432"}, "", true},
+	} {
+		protos = c.protos
+		targets, err := resolveTargets()
+		var got []string
+		for _, target := range targets {
+			got = append(got, target.decoder.Name()+"="+strings.Join(target.ports, ","))
+		}
+		if (err != nil) != c.err || strings.Join(got, " ") != c.want {
+			t.Errorf("resolveTargets(%v) = %v, %v", c.protos, got, err)
+		}
+	}
+
+	// 不指定 --proto 时使用所有协议的 --name_port
+	protos = nil
+	targets, err := resolveTargets()
+	if err != nil || len(targets) != len(ports) {
+		t.Fatalf("resolveTargets() = %v, %v", targets, err)
+	}
+}
@@ -0,0 +1,78 @@
+package decoder
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// StreamFactory 协议的 tcpassembly.StreamFactory，并且可以等待所有解析协程退出
+type StreamFactory interface {
+	tcpassembly.StreamFactory
+	// Wait 需在 assembler FlushAll 之后调用
+	Wait()
+}
+
+// Options 创建 StreamFactory 的参数，每个端口一份
+type Options struct {
+	Port   string
+	Logger *logrus.Logger
+	Sink   event.Sink
+	Replay bool // 离线回放，静默时长按抓包时间而不是本地时间计算
+}
+
+// Decoder 一种协议的解析器，协议包在 init 中调用 Register 注册
+type Decoder interface {
+	// Name 协议名，用于 --proto name=port 和 --name_port
+	Name() string
+	DefaultPorts() []string
+	// Flags 注册协议自己的命令行参数，没有可以留空
+	Flags(fs *pflag.FlagSet)
+	NewFactory(opts Options) StreamFactory
+}
+
+var (
+	mutex    sync.Mutex
+	decoders = make(map[string]Decoder)
+)
+
+// Register 注册解析器，重名会 panic
+func Register(d Decoder) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := decoders[d.Name()]; ok {
+		panic(fmt.Sprintf("decoder: Register called twice for %s", d.Name()))
+	}
+	decoders[d.Name()] = d
+}
+
+// Get 按协议名查找解析器
+func Get(name string) (Decoder, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	d, ok := decoders[name]
+	return d, ok
+}
+
+// All 返回所有已注册的解析器，按协议名排序
+func All() []Decoder {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	all := make([]Decoder, 0, len(decoders))
+	for _, d := range decoders {
+		all = append(all, d)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+	return all
+}
@@ -0,0 +1,187 @@
+package event
+
+import (
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+)
+
+const (
+	ProtocolMySQL = "mysql"
+	ProtocolMongo = "mongo"
+	ProtocolRedis = "redis"
+)
+
+// Event 一条被捕获的语句，三种协议共用
+type Event struct {
+	Protocol     string        `json:"protocol"`
+	ClientAddr   string        `json:"client_addr"`
+	ServerAddr   string        `json:"server_addr"`
+	ConnID       string        `json:"conn_id"`
+	Database     string        `json:"database,omitempty"`
+	User         string        `json:"user,omitempty"`
+	Command      string        `json:"command"`
+	Statement    string        `json:"statement,omitempty"`
+	Args         []any         `json:"args,omitempty"`
+	Timestamp    time.Time     `json:"timestamp"`
+	Latency      time.Duration `json:"latency_ns,omitempty"`
+	Result       string        `json:"result,omitempty"` // ok / error / resultset，未收到响应时为空
+	Columns      []Column      `json:"columns,omitempty"`
+	RowData      [][]any       `json:"row_data,omitempty"` // 由 --capture_rows 保留的结果集行
+	Rows         int64         `json:"rows,omitempty"`
+	AffectedRows int64         `json:"affected_rows,omitempty"`
+	LastInsertID uint64        `json:"last_insert_id,omitempty"`
+	Warnings     int           `json:"warnings,omitempty"`
+	ServerStatus uint16        `json:"server_status,omitempty"`
+	ErrorCode    int           `json:"error_code,omitempty"`
+	SQLState     string        `json:"sql_state,omitempty"`
+	ErrorMessage string        `json:"error_message,omitempty"`
+	ErrorClass   string        `json:"error_class,omitempty"` // 错误分类，如 deadlock、duplicate_key
+	BytesIn      int           `json:"bytes_in,omitempty"`    // 客户端发出的字节数
+	BytesOut     int           `json:"bytes_out,omitempty"`   // 服务端返回的字节数
+	Conn         *ConnInfo     `json:"conn,omitempty"`
+	Session      *Session      `json:"session,omitempty"`
+	Transaction  *Transaction  `json:"transaction,omitempty"`
+	LockConflict *LockConflict `json:"lock_conflict,omitempty"`
+	ErrorSummary *ErrorSummary `json:"error_summary,omitempty"`
+	AuthFailures *AuthFailures `json:"auth_failures,omitempty"`
+	MongoReply   *MongoReply   `json:"mongo_reply,omitempty"`
+	Alert        string        `json:"alert,omitempty"` // 告警类型，普通语句为空
+}
+
+// 告警类型
+const (
+	AlertLongTransaction = "long_transaction"
+	AlertIdleTransaction = "idle_transaction"
+	AlertDeadlock        = "deadlock"
+	AlertLockWaitTimeout = "lock_wait_timeout"
+	AlertBruteForce      = "brute_force"
+)
+
+const (
+	ResultOK        = "ok"
+	ResultError     = "error"
+	ResultResultSet = "resultset"
+)
+
+// Column 结果集的列
+type Column struct {
+	Name  string `json:"name"`
+	Table string `json:"table,omitempty"`
+	Type  string `json:"type"`
+}
+
+// ConnInfo 握手时得到的连接信息，同一连接的事件共用，不要修改
+type ConnInfo struct {
+	ServerVersion string            `json:"server_version,omitempty"`
+	ThreadID      uint32            `json:"thread_id,omitempty"`
+	Capabilities  uint32            `json:"capabilities,omitempty"`
+	Charset       uint8             `json:"charset,omitempty"`
+	AuthPlugin    string            `json:"auth_plugin,omitempty"`
+	Attrs         map[string]string `json:"attrs,omitempty"` // 客户端连接属性，如 program_name、_client_name、_pid
+	TLS           string            `json:"tls,omitempty"`   // 使用 TLS 时为协商的版本和套件，如 TLS 1.3 TLS_AES_128_GCM_SHA256
+	Mongo         *MongoHello       `json:"mongo,omitempty"` // MongoDB 连接的 hello/isMaster 握手
+}
+
+// MongoHello MongoDB 连接上第一个 hello/isMaster 中的客户端信息和服务端的响应
+type MongoHello struct {
+	AppName            string   `json:"app_name,omitempty"` // client.application.name，即连接串中的 appName
+	DriverName         string   `json:"driver_name,omitempty"`
+	DriverVersion      string   `json:"driver_version,omitempty"`
+	OS                 string   `json:"os,omitempty"`                   // client.os 的 type、name、architecture、version
+	Compression        []string `json:"compression,omitempty"`          // 客户端支持的压缩算法
+	SASLSupportedMechs string   `json:"sasl_supported_mechs,omitempty"` // 查询认证机制的用户，如 admin.app
+	Mechanisms         []string `json:"mechanisms,omitempty"`           // 服务端返回的该用户可用的认证机制
+	MaxWireVersion     int      `json:"max_wire_version,omitempty"`
+	SetName            string   `json:"set_name,omitempty"`            // 副本集名
+	IsWritablePrimary  *bool    `json:"is_writable_primary,omitempty"` // 旧版本为 ismaster，没有收到响应时为空
+}
+
+// Session 语句发出时连接的会话状态，同一状态的事件共用，不要修改
+type Session struct {
+	InTransaction bool              `json:"in_transaction"`
+	Autocommit    bool              `json:"autocommit"`
+	Variables     map[string]string `json:"variables,omitempty"` // SET 设置的会话变量和用户变量
+}
+
+// Transaction 一个事务，从开启事务的语句到提交或回滚
+type Transaction struct {
+	Statements     []string      `json:"statements"`
+	StatementCount int           `json:"statement_count"` // 语句总数，Statements 只保留前面一部分
+	Duration       time.Duration `json:"duration_ns"`
+	Idle           time.Duration `json:"idle_ns"`           // 事务中等待客户端发出下一条语句的时间
+	Outcome        string        `json:"outcome,omitempty"` // commit / rollback / implicit / disconnected，告警时为空
+}
+
+// LockConflict 死锁或锁等待超时时，出错的事务和同一服务端上并发的其他事务
+type LockConflict struct {
+	Statements []string                `json:"statements"` // 出错事务的语句，最后一条为出错的语句
+	Concurrent []ConcurrentTransaction `json:"concurrent"`
+}
+
+// ConcurrentTransaction 出错时同一服务端上其他连接正在进行的事务或语句
+type ConcurrentTransaction struct {
+	ConnID     string   `json:"conn_id"`
+	ClientAddr string   `json:"client_addr"`
+	User       string   `json:"user,omitempty"`
+	Statements []string `json:"statements,omitempty"` // 事务中最近的语句
+	Waiting    string   `json:"waiting,omitempty"`    // 出错时正在等待响应的语句
+}
+
+// ErrorSummary 一段时间内的错误统计
+type ErrorSummary struct {
+	Start        time.Time      `json:"start"`
+	Duration     time.Duration  `json:"duration_ns"`
+	Total        int            `json:"total"`
+	Classes      map[string]int `json:"classes"`
+	Fingerprints []ErrorCount   `json:"fingerprints"` // 按语句指纹统计，错误数多的在前
+	Clients      []ErrorCount   `json:"clients"`      // 按客户端 IP 统计，错误数多的在前
+}
+
+// ErrorCount 一个语句指纹或客户端的错误数
+type ErrorCount struct {
+	Key     string         `json:"key"`
+	Count   int            `json:"count"`
+	Classes map[string]int `json:"classes"`
+	Sample  string         `json:"sample,omitempty"` // 最近一条出错的语句
+}
+
+// AuthFailures 滑动窗口内同一客户端的认证失败，User 为空时按客户端 IP 统计
+type AuthFailures struct {
+	Client string        `json:"client"`
+	User   string        `json:"user,omitempty"`
+	Count  int           `json:"count"`
+	Window time.Duration `json:"window_ns"`
+	Users  []string      `json:"users,omitempty"` // 按客户端 IP 统计时尝试过的用户名
+}
+
+// MongoReply MongoDB 命令的响应
+type MongoReply struct {
+	OK          bool              `json:"ok"`
+	CodeName    string            `json:"code_name,omitempty"`
+	N           int64             `json:"n,omitempty"`
+	NModified   int64             `json:"n_modified,omitempty"`
+	WriteErrors []MongoWriteError `json:"write_errors,omitempty"`
+	CursorID    int64             `json:"cursor_id,omitempty"`
+	Batch       int               `json:"batch,omitempty"` // firstBatch/nextBatch 或 OP_REPLY 返回的文档数
+}
+
+// MongoWriteError 批量写入中单个文档的错误
+type MongoWriteError struct {
+	Index  int    `json:"index"`
+	Code   int    `json:"code"`
+	ErrMsg string `json:"errmsg,omitempty"`
+}
+
+// Sink 接收解析出的事件，实现需要支持多个协程同时调用
+type Sink interface {
+	Emit(e *Event)
+}
+
+// Text 返回可读的语句，预编译语句会把参数代入占位符
+func (e *Event) Text() string {
+	if len(e.Args) == 0 {
+		return e.Statement
+	}
+	return helper.ExplainSQL(e.Statement, nil, `'`, e.Args...)
+}
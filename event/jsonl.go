@@ -0,0 +1,249 @@
+package event
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rotateRetry 切分失败后继续写入原文件，间隔该时长后再重试
+const rotateRetry = time.Minute
+
+// JSONLSink 每条事件写一行 JSON，按大小或时间切分文件，切下来的文件用 gzip 压缩
+type JSONLSink struct {
+	path    string
+	maxSize int64         // 单个文件最大字节数，0 表示不限制
+	maxAge  time.Duration // 单个文件最长写入时间，0 表示不限制
+	logger  *logrus.Logger
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time      // 当前文件开始写入的时间
+	retry  time.Time      // 切分失败后，该时间之前不再尝试切分
+	wg     sync.WaitGroup // 后台压缩任务
+}
+
+func NewJSONLSink(path string, maxSize int64, maxAge time.Duration, logger *logrus.Logger) (*JSONLSink, error) {
+	s := &JSONLSink{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		logger:  logger,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONLSink) Emit(e *Event) {
+	line, err := json.Marshal(jsonEvent{Event: e, Args: jsonArgs(e.Args), RowData: jsonRows(e.RowData)})
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("序列化事件失败: %v", err))
+		return
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return
+	}
+	if s.shouldRotate(len(line)) {
+		if err := s.rotate(); err != nil {
+			// 保留原文件继续写入，稍后重试，不丢弃事件
+			s.retry = time.Now().Add(rotateRetry)
+			s.logger.Error(fmt.Sprintf("切分文件失败，继续写入 %s: %v", s.path, err))
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("写入文件失败: %v", err))
+	}
+}
+
+// Close 关闭当前文件并等待压缩任务完成
+func (s *JSONLSink) Close() error {
+	s.mutex.Lock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *JSONLSink) open() error {
+	f, size, opened, err := s.openFile()
+	if err != nil {
+		return err
+	}
+	s.file, s.size, s.opened = f, size, opened
+	return nil
+}
+
+// openFile 以追加方式打开 path，返回已有的大小和开始写入的时间。
+// 已有内容的文件(如重启前写入的)按修改时间计算，避免重启后重新计时
+func (s *JSONLSink) openFile() (*os.File, int64, time.Time, error) {
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, 0, time.Time{}, err
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, time.Time{}, err
+	}
+	opened := time.Now()
+	if info.Size() > 0 && info.ModTime().Before(opened) {
+		opened = info.ModTime()
+	}
+	return f, info.Size(), opened, nil
+}
+
+func (s *JSONLSink) shouldRotate(n int) bool {
+	if s.size == 0 || time.Now().Before(s.retry) {
+		return false
+	}
+	if s.maxSize > 0 && s.size+int64(n) > s.maxSize {
+		return true
+	}
+	return s.maxAge > 0 && time.Since(s.opened) >= s.maxAge
+}
+
+// rotate 把当前文件重命名为带时间的文件名，打开新文件后再关闭原文件并在后台压缩。
+// 失败时保留原文件的句柄，调用方可以继续写入
+func (s *JSONLSink) rotate() error {
+	rotated := s.rotatedName()
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	f, size, opened, err := s.openFile()
+	if err != nil {
+		// 改回原来的文件名，继续写入原文件
+		if rerr := os.Rename(rotated, s.path); rerr != nil {
+			return fmt.Errorf("%v, 恢复文件名 %s 失败: %v", err, rotated, rerr)
+		}
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		s.logger.Error(fmt.Sprintf("关闭文件失败: %s, %v", rotated, err))
+	}
+	s.file, s.size, s.opened = f, size, opened
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := compressFile(rotated); err != nil {
+			s.logger.Error(fmt.Sprintf("压缩文件失败: %s, %v", rotated, err))
+		}
+	}()
+	return nil
+}
+
+// rotatedName 生成带时间的文件名，同一时刻多次切分时追加序号避免覆盖
+func (s *JSONLSink) rotatedName() string {
+	ext := filepath.Ext(s.path)
+	base := fmt.Sprintf("%s-%s", strings.TrimSuffix(s.path, ext), time.Now().Format("20060102T150405.000"))
+	name := base + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	return name
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// compressFile 压缩为 name.gz 并删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// jsonEvent 输出时替换 Args 和 RowData，其余字段沿用 Event 的 json 标签
+type jsonEvent struct {
+	*Event
+	Args    []any   `json:"args,omitempty"`
+	RowData [][]any `json:"row_data,omitempty"`
+}
+
+// jsonRows 每行的值按参数的规则转换，NULL 输出为 null
+func jsonRows(rows [][]any) [][]any {
+	if len(rows) == 0 {
+		return nil
+	}
+	out := make([][]any, len(rows))
+	for i, row := range rows {
+		out[i] = jsonArgs(row)
+		if out[i] == nil {
+			out[i] = []any{}
+		}
+	}
+	return out
+}
+
+// jsonArgs 把参数转换成稳定的 JSON 表示：文本按字符串输出，二进制输出为 0x 开头的十六进制
+func jsonArgs(args []any) []any {
+	if len(args) == 0 {
+		return nil
+	}
+	out := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case []byte:
+			if utf8.Valid(v) {
+				out[i] = string(v)
+			} else {
+				out[i] = "0x" + hex.EncodeToString(v)
+			}
+		case time.Duration:
+			out[i] = v.String()
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package event
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestJSONLSinkRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	s, err := NewJSONLSink(path, 300, 0, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		s.Emit(&Event{
+			Protocol:  ProtocolMySQL,
+			Command:   "COM_STMT_EXECUTE",
+			Statement: "SELECT * FROM user WHERE name = ? AND data = ?",
+			Args:      []any{[]byte("jackson"), []byte{0xff, 0x00}},
+			Timestamp: time.Unix(1700000000, 0),
+		})
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "events-*.jsonl.gz"))
+	if len(rotated) == 0 {
+		t.Fatal("no rotated segment")
+	}
+
+	var lines int
+	for _, name := range append(rotated, path) {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var scanner *bufio.Scanner
+		if filepath.Ext(name) == ".gz" {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scanner = bufio.NewScanner(zr)
+		} else {
+			scanner = bufio.NewScanner(f)
+		}
+		for scanner.Scan() {
+			var m map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+				t.Fatal(err)
+			}
+			args := m["args"].([]any)
+			if args[0] != "jackson" || args[1] != "0xff00" {
+				t.Errorf("args = %v", args)
+			}
+			lines++
+		}
+		f.Close()
+	}
+	if lines != 10 {
+		t.Errorf("lines = %d, want 10", lines)
+	}
+}
+
+func TestJSONLSinkRotateFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	s, err := NewJSONLSink(path, 1, 0, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Emit(&Event{Protocol: ProtocolRedis, Command: "GET", Statement: "GET a"})
+	// 文件被删除后重命名失败，继续写入原来的句柄
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	size := s.size
+	s.Emit(&Event{Protocol: ProtocolRedis, Command: "GET", Statement: "GET b"})
+	if s.file == nil || s.size <= size || s.retry.IsZero() {
+		t.Fatalf("event dropped after failed rotate: file %v, size %d, retry %v", s.file, s.size, s.retry)
+	}
+}
+
+func TestJSONLSinkReopenAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	if err := os.WriteFile(path, []byte("{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启前写入的文件已经超过 maxAge，第一条事件就切分
+	s, err := NewJSONLSink(path, 0, time.Hour, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Emit(&Event{Protocol: ProtocolRedis, Command: "GET", Statement: "GET a"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, _ := filepath.Glob(filepath.Join(dir, "events-*.jsonl.gz")); len(rotated) != 1 {
+		t.Fatalf("rotated = %v, want 1 segment", rotated)
+	}
+}
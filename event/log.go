@@ -0,0 +1,73 @@
+package event
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// LogSink 把事件写入 logrus 文本日志，时间使用抓包时间(精确到微秒)，
+// 消息为代入参数后的语句，proto/client/server/db/latency 等属性作为字段输出，有告警时使用 warn 级别
+type LogSink struct {
+	logger *logrus.Logger
+}
+
+func NewLogSink(logger *logrus.Logger) *LogSink {
+	return &LogSink{logger: logger}
+}
+
+func (s *LogSink) Emit(e *Event) {
+	fields := logrus.Fields{
+		"proto":  e.Protocol,
+		"client": e.ClientAddr,
+		"server": e.ServerAddr,
+	}
+	if len(e.Database) != 0 {
+		fields["db"] = e.Database
+	}
+	if len(e.User) != 0 {
+		fields["user"] = e.User
+	}
+	if e.Conn != nil {
+		if app := e.Conn.Attrs["program_name"]; len(app) != 0 {
+			fields["app"] = app
+		} else if e.Conn.Mongo != nil && len(e.Conn.Mongo.AppName) != 0 {
+			fields["app"] = e.Conn.Mongo.AppName
+		}
+	}
+	if e.Session != nil && e.Session.InTransaction {
+		fields["in_tx"] = true
+	}
+	if len(e.Result) != 0 {
+		fields["result"] = e.Result
+	}
+	if e.Latency != 0 {
+		fields["latency"] = e.Latency
+	}
+	if e.Rows != 0 {
+		fields["rows"] = e.Rows
+	}
+	if e.AffectedRows != 0 {
+		fields["affected"] = e.AffectedRows
+	}
+	if e.ErrorCode != 0 {
+		fields["errno"] = e.ErrorCode
+	}
+	if len(e.ErrorMessage) != 0 {
+		fields["error"] = e.ErrorMessage
+	}
+	if len(e.ErrorClass) != 0 {
+		fields["error_class"] = e.ErrorClass
+	}
+	if e.LockConflict != nil {
+		fields["concurrent"] = len(e.LockConflict.Concurrent)
+	}
+	if e.Transaction != nil {
+		fields["statements"] = e.Transaction.StatementCount
+		fields["idle"] = e.Transaction.Idle
+	}
+	entry := s.logger.WithTime(e.Timestamp).WithFields(fields)
+	if len(e.Alert) != 0 {
+		entry.WithField("alert", e.Alert).Warn(e.Text())
+		return
+	}
+	entry.Info(e.Text())
+}
@@ -0,0 +1,28 @@
+package event
+
+import (
+	"errors"
+	"io"
+)
+
+// MultiSink 把事件依次交给多个 Sink
+type MultiSink []Sink
+
+func (m MultiSink) Emit(e *Event) {
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
+
+// Close 关闭所有实现了 io.Closer 的 Sink
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,26 @@
+package event
+
+import (
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Truncated 超过长度上限被截断的参数值，Size 为原始长度
+type Truncated struct {
+	Data []byte
+	Size int
+}
+
+// String 输出保留的内容和截断标记，二进制内容按十六进制输出
+func (t Truncated) String() string {
+	marker := fmt.Sprintf("...(truncated, %d bytes)", t.Size)
+	if utf8.Valid(t.Data) {
+		return string(t.Data) + marker
+	}
+	return "0x" + hex.EncodeToString(t.Data) + marker
+}
+
+func (t Truncated) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
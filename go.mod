@@ -3,13 +3,15 @@ module github.com/JacksonChan-X/sql-sniffer
 go 1.23.6
 
 require (
-	github.com/40t/go-sniffer v0.0.0-20201023123058-add66245ba50
 	github.com/go-sql-driver/mysql v1.9.1
 	github.com/google/gopacket v1.1.19
 	github.com/iancoleman/strcase v0.3.0
+	github.com/klauspost/compress v1.18.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
 	go.mongodb.org/mongo-driver v1.17.3
+	golang.org/x/crypto v0.31.0
 	golang.org/x/sync v0.12.0
 	gorm.io/driver/mysql v1.5.7
 	gorm.io/gorm v1.25.12
@@ -20,7 +22,6 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
@@ -1,4 +1,4 @@
-package client
+package helper
 
 import (
 	"database/sql/driver"
@@ -9,12 +9,10 @@ import (
 	"strings"
 	"time"
 	"unicode"
-
-	"gorm.io/gorm/utils"
 )
 
 const (
-	tmFmtWithMS = "2006-01-02 15:04:05.999"
+	tmFmtWithMS = "2006-01-02 15:04:05.999999"
 	tmFmtZero   = "0000-00-00 00:00:00"
 	nullStr     = "NULL"
 )
@@ -45,6 +43,24 @@ func isNumeric(k reflect.Kind) bool {
 	}
 }
 
+// formatDuration 按 MySQL TIME 的格式输出，如 -838:59:59.000001
+func formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	h := d / time.Hour
+	m := d % time.Hour / time.Minute
+	sec := d % time.Minute / time.Second
+	us := d % time.Second / time.Microsecond
+	if us == 0 {
+		return fmt.Sprintf("%s%02d:%02d:%02d", sign, h, m, sec)
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, h, m, sec, us)
+}
+
+// ExplainSQL 把参数代入 SQL 的占位符，生成可读的语句
 func ExplainSQL(sql string, numericPlaceholder *regexp.Regexp, escaper string, avars ...interface{}) string {
 	var (
 		convertParams func(interface{}, int)
@@ -71,6 +87,8 @@ func ExplainSQL(sql string, numericPlaceholder *regexp.Regexp, escaper string, a
 			} else {
 				vars[idx] = nullStr
 			}
+		case time.Duration:
+			vars[idx] = escaper + formatDuration(v) + escaper
 		case driver.Valuer:
 			reflectValue := reflect.ValueOf(v)
 			if v != nil && reflectValue.IsValid() && ((reflectValue.Kind() == reflect.Ptr && !reflectValue.IsNil()) || reflectValue.Kind() != reflect.Ptr) {
@@ -104,7 +122,7 @@ func ExplainSQL(sql string, numericPlaceholder *regexp.Regexp, escaper string, a
 				vars[idx] = escaper + "<binary>" + escaper
 			}
 		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-			vars[idx] = utils.ToString(v)
+			vars[idx] = fmt.Sprintf("%d", v)
 		case float32:
 			vars[idx] = strconv.FormatFloat(float64(v), 'f', -1, 32)
 		case float64:
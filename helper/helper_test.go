@@ -1,7 +1,12 @@
 package helper
 
 import (
+	"io"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
 )
 
 func TestGetIPByInterface(t *testing.T) {
@@ -12,3 +17,41 @@ func TestGetIPByInterface(t *testing.T) {
 	t.Log(ip4s)
 	t.Log(ip6s)
 }
+
+func TestTimedStream(t *testing.T) {
+	s := NewTimedStream()
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	go func() {
+		s.Reassembled([]tcpassembly.Reassembly{
+			{Bytes: []byte("abc"), Seen: t0},
+			{Bytes: []byte("defg"), Seen: t0.Add(time.Second)},
+		})
+		s.ReassemblyComplete()
+	}()
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if ts := s.Seen(0); !ts.Equal(t0) {
+		t.Errorf("Seen(0) = %v, want %v", ts, t0)
+	}
+
+	start := s.Offset()
+	buf = make([]byte, 3)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if ts := s.Seen(start); !ts.Equal(t0) {
+		t.Errorf("Seen(%d) = %v, want %v", start, ts, t0)
+	}
+	if ts := s.Seen(s.Offset()); !ts.Equal(t0.Add(time.Second)) {
+		t.Errorf("Seen(%d) = %v, want %v", s.Offset(), ts, t0.Add(time.Second))
+	}
+	tcpreader.DiscardBytesToEOF(s)
+}
+
+func TestExplainSQL(t *testing.T) {
+	sql := "SELECT * FROM `user` WHERE name = ? AND phone = ? ORDER BY `user`.`name` LIMIT ?"
+	t.Log(ExplainSQL(sql, nil, `'`, "jackson", 189593868))
+}
@@ -3,6 +3,8 @@ package helper
 import (
 	"fmt"
 	"net"
+
+	"github.com/google/gopacket"
 )
 
 // GetAllInterfaces 获取本机所有网卡信息
@@ -33,3 +35,10 @@ func OutboundIP() string {
 	ip, _, _ := net.SplitHostPort(conn.LocalAddr().String())
 	return ip
 }
+
+// FlowAddr 返回一个方向上源和目的的 ip:port
+func FlowAddr(netFlow, transport gopacket.Flow) (src, dst string) {
+	src = net.JoinHostPort(netFlow.Src().String(), transport.Src().String())
+	dst = net.JoinHostPort(netFlow.Dst().String(), transport.Dst().String())
+	return src, dst
+}
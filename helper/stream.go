@@ -0,0 +1,122 @@
+package helper
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
+)
+
+// TimedStream 在 tcpreader.ReaderStream 的基础上记录每段数据的抓包时间，
+// 解析协程可以据此得到某个字节真正出现在网络上的时间
+type TimedStream struct {
+	tcpreader.ReaderStream
+	mutex sync.Mutex
+	marks []timeMark
+	total int64 // 已交给 ReaderStream 的字节数
+	read  int64 // 已被读取的字节数，只在读协程中访问
+}
+
+type timeMark struct {
+	offset int64
+	seen   time.Time
+}
+
+func NewTimedStream() *TimedStream {
+	return &TimedStream{ReaderStream: tcpreader.NewReaderStream()}
+}
+
+// Reassembled 实现 tcpassembly.Stream，先记录时间再把数据交给 ReaderStream
+func (s *TimedStream) Reassembled(reassembly []tcpassembly.Reassembly) {
+	s.mutex.Lock()
+	for _, r := range reassembly {
+		if len(r.Bytes) == 0 {
+			continue
+		}
+		s.marks = append(s.marks, timeMark{offset: s.total, seen: r.Seen})
+		s.total += int64(len(r.Bytes))
+	}
+	s.mutex.Unlock()
+	s.ReaderStream.Reassembled(reassembly)
+}
+
+func (s *TimedStream) Read(p []byte) (int, error) {
+	n, err := s.ReaderStream.Read(p)
+	s.read += int64(n)
+	return n, err
+}
+
+// Offset 返回已经读取的字节数
+func (s *TimedStream) Offset() int64 {
+	return s.read
+}
+
+// Seen 返回第 offset 个字节的抓包时间，offset 需单调递增，更早的记录会被丢弃。
+// 没有记录时返回当前时间
+func (s *TimedStream) Seen(offset int64) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := 0
+	for i+1 < len(s.marks) && s.marks[i+1].offset <= offset {
+		i++
+	}
+	if i >= len(s.marks) {
+		return time.Now()
+	}
+	s.marks = s.marks[i:]
+	return s.marks[0].seen
+}
+
+// Stream 带抓包时间的字节流，TimedStream 和 TLS 解密后的流都实现了该接口
+type Stream interface {
+	io.Reader
+	// Offset 返回已经读取的字节数
+	Offset() int64
+	// Seen 返回第 offset 个字节的抓包时间
+	Seen(offset int64) time.Time
+}
+
+// PeekStream 可以预读的 Stream，预读的字节在下一次 Read 时返回
+type PeekStream struct {
+	Stream
+	peek []byte
+}
+
+func NewPeekStream(s Stream) *PeekStream {
+	return &PeekStream{Stream: s}
+}
+
+// Peek 读取但不消费接下来的 n 个字节
+func (s *PeekStream) Peek(n int) ([]byte, error) {
+	for len(s.peek) < n {
+		buf := make([]byte, n-len(s.peek))
+		k, err := s.Stream.Read(buf)
+		s.peek = append(s.peek, buf[:k]...)
+		if err != nil {
+			return s.peek, err
+		}
+	}
+	return s.peek[:n], nil
+}
+
+// Buffered 返回已预读、尚未消费的字节数
+func (s *PeekStream) Buffered() int {
+	return len(s.peek)
+}
+
+func (s *PeekStream) Read(p []byte) (int, error) {
+	if len(s.peek) > 0 {
+		n := copy(p, s.peek)
+		s.peek = s.peek[n:]
+		return n, nil
+	}
+	return s.Stream.Read(p)
+}
+
+// Offset 返回已经消费的字节数，不包括预读的部分
+func (s *PeekStream) Offset() int64 {
+	return s.Stream.Offset() - int64(len(s.peek))
+}
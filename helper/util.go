@@ -3,20 +3,8 @@ package helper
 import (
 	"bytes"
 	"io"
-	"time"
 )
 
-func GetNowStr(isClient bool) string {
-	var msg string
-	msg += time.Now().Format("2006-01-02 15:04:05")
-	if isClient {
-		msg += "| cli -> ser |"
-	} else {
-		msg += "| ser -> cli |"
-	}
-	return msg
-}
-
 func LengthEncodedInt(input []byte) (num uint64, isNull bool, n int) {
 
 	switch input[0] {
@@ -0,0 +1,74 @@
+package mongo
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// commandDocument 返回 OP_MSG 类型 0 的命令文档，OP_QUERY 发往 $cmd 的命令文档，
+// 或 OP_REPLY、OP_COMMANDREPLY 的第一个文档
+func commandDocument(p *packet) bson.Raw {
+	r := newMsgReader(p.data)
+	switch p.opCode {
+	case OP_MSG:
+		if msg, err := parseSections(p.data); err == nil {
+			return msg.Body
+		}
+		return nil
+	case OP_QUERY:
+		r.Skip(4) // flags
+		if name := r.CString(); !strings.HasSuffix(name, ".$cmd") {
+			return nil
+		}
+		r.Skip(8) // numberToSkip, numberToReturn
+		return unwrapQuery(r.Document())
+	case OP_REPLY:
+		r.Skip(20) // responseFlags, cursorID, startingFrom, numberReturned
+	case OP_COMMANDREPLY:
+	default:
+		return nil
+	}
+	return r.Document()
+}
+
+// unwrapQuery 旧驱动带读偏好时把命令包装为 {$query: 命令, $readPreference: ...}
+func unwrapQuery(doc bson.Raw) bson.Raw {
+	if cmd, ok := doc.Lookup("$query").DocumentOK(); ok {
+		return cmd
+	}
+	return doc
+}
+
+// authCommand 认证命令返回用户名，saslContinue 沿用 saslStart 中的用户
+func (stm *stream) authCommand(doc bson.Raw) (string, bool) {
+	elems, err := doc.Elements()
+	if err != nil || len(elems) == 0 {
+		return "", false
+	}
+	switch elems[0].Key() {
+	case "saslStart":
+		// SCRAM 的 client-first-message: n,,n=user,r=nonce
+		stm.authUser = ""
+		if _, payload, ok := doc.Lookup("payload").BinaryOK(); ok {
+			stm.authUser = scramUser(payload)
+		}
+		return stm.authUser, true
+	case "saslContinue":
+		return stm.authUser, true
+	case "authenticate":
+		user, _ := doc.Lookup("user").StringValueOK()
+		return user, true
+	}
+	return "", false
+}
+
+// scramUser 从 SCRAM 的 client-first-message 中取出用户名
+func scramUser(payload []byte) string {
+	for _, attr := range strings.Split(string(payload), ",") {
+		if user, ok := strings.CutPrefix(attr, "n="); ok {
+			return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(user)
+		}
+	}
+	return ""
+}
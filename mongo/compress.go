@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxMessageSize 服务端允许的最大消息长度 (maxMessageSizeBytes)
+const maxMessageSize = 48 * 1024 * 1024
+
+// OP_COMPRESSED 的 compressorId
+const (
+	compressorNoop   = 0
+	compressorSnappy = 1
+	compressorZlib   = 2
+	compressorZstd   = 3
+)
+
+var ErrCompressedMessage = errors.New("malformed OP_COMPRESSED message")
+
+// zstdDecoder DecodeAll 可以并发调用
+var zstdDecoder, _ = zstd.NewReader(nil,
+	zstd.WithDecoderConcurrency(0),
+	zstd.WithDecoderMaxMemory(maxMessageSize),
+)
+
+// decompress 展开 OP_COMPRESSED: originalOpcode(4) + uncompressedSize(4) + compressorId(1) + 压缩后的消息，
+// 展开后 opCode 和负载替换为原始消息，length 仍是线上的长度。
+// uncompressedSize 来自线上，不按它预先分配内存
+func (p *packet) decompress() error {
+	if len(p.data) < 9 {
+		return ErrCompressedMessage
+	}
+	opCode := int(int32(binary.LittleEndian.Uint32(p.data[0:4])))
+	size := int(int32(binary.LittleEndian.Uint32(p.data[4:8])))
+	compressor := p.data[8]
+	data := p.data[9:]
+	if size < 0 || size > maxMessageSize {
+		return fmt.Errorf("%w: uncompressed size %d", ErrCompressedMessage, size)
+	}
+
+	var (
+		plain []byte
+		err   error
+	)
+	switch compressor {
+	case compressorNoop:
+		plain = data
+	case compressorSnappy:
+		var n int
+		if n, err = snappy.DecodedLen(data); err == nil && n != size {
+			err = fmt.Errorf("snappy length %d", n)
+		}
+		if err == nil {
+			plain, err = snappy.Decode(nil, data)
+		}
+	case compressorZlib:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(data)); err == nil {
+			plain, err = io.ReadAll(io.LimitReader(zr, int64(size)+1))
+			zr.Close()
+		}
+	case compressorZstd:
+		plain, err = zstdDecoder.DecodeAll(data, nil)
+	default:
+		return fmt.Errorf("%w: unknown compressor %d", ErrCompressedMessage, compressor)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCompressedMessage, err)
+	}
+	if len(plain) != size {
+		return fmt.Errorf("%w: uncompressed size %d, expect %d", ErrCompressedMessage, len(plain), size)
+	}
+
+	p.opCode = opCode
+	p.data = plain
+	return nil
+}
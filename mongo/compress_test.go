@@ -0,0 +1,65 @@
+package mongo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDecompress(t *testing.T) {
+	plain := opMsg(t, 0, bson.D{{Key: "find", Value: "orders"}, {Key: "$db", Value: "shop"}})
+	compressors := map[byte]func([]byte) []byte{
+		compressorNoop:   func(b []byte) []byte { return b },
+		compressorSnappy: func(b []byte) []byte { return snappy.Encode(nil, b) },
+		compressorZlib: func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := zlib.NewWriter(&buf)
+			w.Write(b)
+			w.Close()
+			return buf.Bytes()
+		},
+		compressorZstd: func(b []byte) []byte {
+			enc, _ := zstd.NewWriter(nil)
+			defer enc.Close()
+			return enc.EncodeAll(b, nil)
+		},
+	}
+	wrap := func(id byte, size int, body []byte) *packet {
+		data := binary.LittleEndian.AppendUint32(nil, OP_MSG)
+		data = binary.LittleEndian.AppendUint32(data, uint32(size))
+		data = append(append(data, id), body...)
+		return &packet{opCode: OP_COMPRESSED, data: data, length: len(data)}
+	}
+
+	for id, compress := range compressors {
+		p := wrap(id, len(plain), compress(plain))
+		length := p.length
+		if err := p.decompress(); err != nil {
+			t.Fatalf("compressor %d: %v", id, err)
+		}
+		if p.opCode != OP_MSG || !bytes.Equal(p.data, plain) || p.length != length {
+			t.Fatalf("compressor %d: unexpected packet %+v", id, p)
+		}
+		if doc := commandDocument(p); doc == nil || doc.Lookup("find").StringValue() != "orders" {
+			t.Fatalf("compressor %d: unexpected document %v", id, doc)
+		}
+	}
+
+	for _, p := range []*packet{
+		wrap(compressorZstd, len(plain)+1, compressors[compressorZstd](plain)),
+		wrap(compressorZlib, len(plain), []byte("garbage")),
+		wrap(9, len(plain), plain),
+		wrap(compressorNoop, maxMessageSize+1, plain),
+		{opCode: OP_COMPRESSED, data: []byte{1, 2, 3}},
+	} {
+		if err := p.decompress(); !errors.Is(err, ErrCompressedMessage) || p.opCode != OP_COMPRESSED {
+			t.Fatalf("expect error, got %v %+v", err, p)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package mongo
 
+import "fmt"
+
 const (
 	OP_REPLY  = 1    //Reply to a client request. responseTo is set.
 	OP_UPDATE = 2001 //Update document.
@@ -13,5 +15,28 @@ const (
 
 	OP_COMMAND      = 2010 //Cluster internal protocol representing a command request.
 	OP_COMMANDREPLY = 2011 //Cluster internal protocol representing a reply to an OP_COMMAND.
+	OP_COMPRESSED   = 2012 //Wraps other opcodes using compression.
 	OP_MSG          = 2013 //Send a message using the format introduced in MongoDB 3.6.
 )
+
+var opNames = map[int]string{
+	OP_REPLY:        "OP_REPLY",
+	OP_UPDATE:       "OP_UPDATE",
+	OP_INSERT:       "OP_INSERT",
+	OP_QUERY:        "OP_QUERY",
+	OP_GET_MORE:     "OP_GET_MORE",
+	OP_DELETE:       "OP_DELETE",
+	OP_KILL_CURSORS: "OP_KILL_CURSORS",
+	OP_COMMAND:      "OP_COMMAND",
+	OP_COMMANDREPLY: "OP_COMMANDREPLY",
+	OP_COMPRESSED:   "OP_COMPRESSED",
+	OP_MSG:          "OP_MSG",
+}
+
+// OpName 返回 opCode 对应的名称
+func OpName(opCode int) string {
+	if name, ok := opNames[opCode]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_UNKNOWN(%d)", opCode)
+}
@@ -0,0 +1,29 @@
+package mongo
+
+import (
+	"github.com/JacksonChan-X/sql-sniffer/decoder"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	decoder.Register(Decoder{})
+}
+
+// Decoder 注册到 decoder 包的 MongoDB 解析器
+type Decoder struct{}
+
+func (Decoder) Name() string {
+	return event.ProtocolMongo
+}
+
+func (Decoder) DefaultPorts() []string {
+	return []string{"27017"}
+}
+
+func (Decoder) Flags(fs *pflag.FlagSet) {}
+
+func (Decoder) NewFactory(opts decoder.Options) decoder.StreamFactory {
+	return NewMongoDBStreamFactory(opts.Port, opts.Logger, opts.Sink)
+}
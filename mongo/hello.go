@@ -0,0 +1,78 @@
+package mongo
+
+import (
+	"strings"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// isHello 连接握手和拓扑监控使用的 hello 命令，旧版本为 isMaster
+func isHello(doc bson.Raw) bool {
+	switch commandName(doc) {
+	case "hello", "isMaster", "ismaster":
+		return true
+	}
+	return false
+}
+
+// clientHello 从连接上第一个 hello 中取出客户端信息，返回 true 表示需要解析它的响应
+func (stm *stream) clientHello(doc bson.Raw) bool {
+	if stm.conn != nil || !isHello(doc) {
+		return false
+	}
+	hello := &event.MongoHello{}
+	client, _ := doc.Lookup("client").DocumentOK()
+	hello.AppName, _ = client.Lookup("application", "name").StringValueOK()
+	hello.DriverName, _ = client.Lookup("driver", "name").StringValueOK()
+	hello.DriverVersion, _ = client.Lookup("driver", "version").StringValueOK()
+	if osDoc, ok := client.Lookup("os").DocumentOK(); ok {
+		var parts []string
+		for _, key := range []string{"type", "name", "architecture", "version"} {
+			if v, _ := osDoc.Lookup(key).StringValueOK(); len(v) != 0 {
+				parts = append(parts, v)
+			}
+		}
+		hello.OS = strings.Join(parts, " ")
+	}
+	hello.Compression = stringArray(doc.Lookup("compression"))
+	hello.SASLSupportedMechs, _ = doc.Lookup("saslSupportedMechs").StringValueOK()
+	stm.conn = &event.ConnInfo{Mongo: hello}
+	return true
+}
+
+// serverHello 用 hello 的响应补充服务端信息。ConnInfo 被之前的事件共用，复制后再修改
+func (stm *stream) serverHello(doc bson.Raw) {
+	hello := *stm.conn.Mongo
+	hello.Mechanisms = stringArray(doc.Lookup("saslSupportedMechs"))
+	if v, ok := doc.Lookup("maxWireVersion").AsInt64OK(); ok {
+		hello.MaxWireVersion = int(v)
+	}
+	hello.SetName, _ = doc.Lookup("setName").StringValueOK()
+	for _, key := range []string{"isWritablePrimary", "ismaster"} {
+		if v, ok := doc.Lookup(key).BooleanOK(); ok {
+			hello.IsWritablePrimary = &v
+			break
+		}
+	}
+	conn := *stm.conn
+	conn.Mongo = &hello
+	stm.conn = &conn
+}
+
+// stringArray 字符串数组中的字符串，忽略其他类型的元素
+func stringArray(v bson.RawValue) []string {
+	array, ok := v.ArrayOK()
+	if !ok {
+		return nil
+	}
+	values, _ := array.Values()
+	var result []string
+	for _, v := range values {
+		if s, ok := v.StringValueOK(); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
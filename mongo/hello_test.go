@@ -0,0 +1,73 @@
+package mongo
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestHello(t *testing.T) {
+	var sink sliceSink
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	stm := &stream{logger: logger, sink: &sink}
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// 旧驱动用 OP_QUERY 发往 admin.$cmd 的 isMaster 握手，带读偏好时包装在 $query 中
+	isMaster, _ := bson.Marshal(bson.D{{Key: "$query", Value: bson.D{
+		{Key: "isMaster", Value: 1},
+		{Key: "client", Value: bson.D{
+			{Key: "application", Value: bson.D{{Key: "name", Value: "order-service"}}},
+			{Key: "driver", Value: bson.D{{Key: "name", Value: "mongo-go-driver"}, {Key: "version", Value: "1.17.3"}}},
+			{Key: "os", Value: bson.D{{Key: "type", Value: "linux"}, {Key: "architecture", Value: "amd64"}}},
+		}},
+		{Key: "compression", Value: bson.A{"zstd", "snappy"}},
+		{Key: "saslSupportedMechs", Value: "admin.app"},
+	}}, {Key: "$readPreference", Value: bson.D{{Key: "mode", Value: "primaryPreferred"}}}})
+	query := append(binary.LittleEndian.AppendUint32(nil, 0), "admin.$cmd\x00"...)
+	query = append(append(query, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff), isMaster...)
+	stm.resolveClientPacket(&packet{isClientFlow: true, requestID: 1, opCode: OP_QUERY, data: query, length: len(query), timestamp: t0})
+	first := stm.conn
+
+	reply, _ := bson.Marshal(bson.D{{Key: "ismaster", Value: true}, {Key: "setName", Value: "rs0"}, {Key: "maxWireVersion", Value: 21},
+		{Key: "saslSupportedMechs", Value: bson.A{"SCRAM-SHA-256"}}, {Key: "ok", Value: 1.0}})
+	data := append(make([]byte, 20), reply...)
+	binary.LittleEndian.PutUint32(data[16:20], 1) // numberReturned
+	stm.resolveServerPacket(&packet{responseTo: 1, opCode: OP_REPLY, data: data, length: len(data), timestamp: t0.Add(time.Millisecond)})
+
+	// 之后的 hello 是心跳，不输出也不覆盖连接信息
+	for i, cmd := range []bson.D{
+		{{Key: "hello", Value: 1}, {Key: "client", Value: bson.D{{Key: "application", Value: bson.D{{Key: "name", Value: "other"}}}}}, {Key: "$db", Value: "admin"}},
+		{{Key: "isMaster", Value: 1}, {Key: "$db", Value: "admin"}},
+		{{Key: "find", Value: "orders"}, {Key: "$db", Value: "shop"}},
+	} {
+		msg := opMsg(t, 0, cmd)
+		stm.resolveClientPacket(&packet{isClientFlow: true, requestID: uint32(i + 2), opCode: OP_MSG, data: msg, length: len(msg), timestamp: t0.Add(time.Second)})
+	}
+	stm.flush()
+
+	if len(sink) != 2 || sink[1].Command != "find" {
+		t.Fatalf("unexpected events: %+v", sink)
+	}
+	hello := sink[0].Conn.Mongo
+	if sink[0].Command != "isMaster" || hello.AppName != "order-service" || hello.DriverName != "mongo-go-driver" || hello.DriverVersion != "1.17.3" ||
+		hello.OS != "linux amd64" || len(hello.Compression) != 2 || hello.SASLSupportedMechs != "admin.app" {
+		t.Fatalf("unexpected client hello: %+v", hello)
+	}
+	if hello.SetName != "rs0" || hello.MaxWireVersion != 21 || hello.IsWritablePrimary == nil || !*hello.IsWritablePrimary ||
+		len(hello.Mechanisms) != 1 {
+		t.Fatalf("unexpected server hello: %+v", hello)
+	}
+	if first.Mongo.SetName != "" {
+		t.Fatalf("shared ConnInfo modified: %+v", first.Mongo)
+	}
+	for _, e := range sink[1:] {
+		if e.Conn != sink[0].Conn {
+			t.Fatalf("unexpected conn of %s: %+v", e.Command, e.Conn.Mongo)
+		}
+	}
+}
@@ -6,25 +6,33 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/40t/go-sniffer/plugSrc/mongodb/build/bson"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+	"github.com/JacksonChan-X/sql-sniffer/tlsdecrypt"
+
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
 	"github.com/google/gopacket/tcpassembly/tcpreader"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type MongoDBStreamFactory struct {
 	Logger *logrus.Logger
+	Sink   event.Sink
 	Port   string
+	mongo  *MongoDB
+	wg     sync.WaitGroup
 }
 
 type MongoDBStream struct {
 	net, transport gopacket.Flow
-	buf            tcpreader.ReaderStream
+	buf            *helper.TimedStream
 }
 
 type MongoDB struct {
@@ -32,13 +40,22 @@ type MongoDB struct {
 	source map[string]*stream
 	mutex  sync.Mutex
 	logger *logrus.Logger
+	sink   event.Sink
+	wg     sync.WaitGroup
 }
 
 type stream struct {
-	packets   chan *packet
-	logger    *logrus.Logger
-	privateIp string
-	publicIp  string
+	id         string
+	packets    chan *packet
+	logger     *logrus.Logger
+	sink       event.Sink
+	clientAddr string
+	serverAddr string
+	refs       int // 仍在读取该连接的方向数，归零时关闭packets
+	tls        *tlsdecrypt.Conn
+	pending    map[uint32]*request // 等待响应的请求，按 requestID
+	authUser   string              // saslStart 中的用户，saslContinue 沿用
+	conn       *event.ConnInfo     // 第一个 hello 得到的连接信息，之后的事件共用
 }
 
 type packet struct {
@@ -46,96 +63,167 @@ type packet struct {
 	length       int
 	requestID    uint32
 	responseTo   uint32
-	opCode       int // request type
-	payload      io.Reader
+	opCode       int       // request type
+	data         []byte    // 消息头之后的全部负载
+	timestamp    time.Time // 包首字节的抓包时间
 }
 
-// OpMsg 代表 OP_MSG 结构
-type OpMsg struct {
-	FlagBits  uint32
-	Sections  []bson.M
-	Checksum  uint32 // 可选的 CRC-32C 校验和
-	HasCRC32C bool   // 是否包含 CRC-32C
-}
+var (
+	ErrMessageLength = errors.New("invalid message length")
+	ErrUnknownOpCode = errors.New("unknown opCode")
+)
 
-type Section struct {
-	PayloadType byte
-	Document    bson.M
-	Identifier  string
-	Documents   []bson.M
+func NewInstance(port string, logger *logrus.Logger, sink event.Sink) *MongoDB {
+	return &MongoDB{
+		port:   port,
+		source: make(map[string]*stream),
+		logger: logger,
+		sink:   sink,
+		mutex:  sync.Mutex{},
+	}
 }
 
-var MongoDBInstance *MongoDB
-var ErrTimeOut = errors.New("stream timeout")
-
-func NewInstance(port string, logger *logrus.Logger) *MongoDB {
-	if MongoDBInstance == nil {
-		MongoDBInstance = &MongoDB{
-			port:   port,
-			source: make(map[string]*stream),
-			logger: logger,
-			mutex:  sync.Mutex{},
-		}
+func NewMongoDBStreamFactory(port string, logger *logrus.Logger, sink event.Sink) *MongoDBStreamFactory {
+	return &MongoDBStreamFactory{
+		Logger: logger,
+		Sink:   sink,
+		Port:   port,
+		mongo:  NewInstance(port, logger, sink),
 	}
-	return MongoDBInstance
 }
 
 func (m *MongoDBStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
 	ps := &MongoDBStream{
 		net:       net,
 		transport: transport,
-		buf:       tcpreader.NewReaderStream(),
+		buf:       helper.NewTimedStream(),
 	}
 
-	mongodbInstance := NewInstance(m.Port, m.Logger)
-	go mongodbInstance.ResolveStream(net, transport, &ps.buf)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.mongo.ResolveStream(net, transport, ps.buf)
+	}()
+
+	return ps.buf
+}
 
-	return &ps.buf
+// Wait 等待所有流的解析协程退出，需在 assembler FlushAll 之后调用
+func (m *MongoDBStreamFactory) Wait() {
+	m.wg.Wait()
+	m.mongo.wg.Wait()
 }
 
-func (m *MongoDB) ResolveStream(net, transport gopacket.Flow, buf io.Reader) {
+func (m *MongoDB) ResolveStream(net, transport gopacket.Flow, buf *helper.TimedStream) {
 	streamID := fmt.Sprintf("%v:%v", net.FastHash(), transport.FastHash())
 
-	if _, ok := m.source[streamID]; !ok {
-		stream := &stream{
+	m.mutex.Lock()
+	stm, ok := m.source[streamID]
+	if !ok {
+		stm = &stream{
+			id:      streamID,
 			packets: make(chan *packet, 100),
 			logger:  m.logger,
+			sink:    m.sink,
+			tls:     tlsdecrypt.NewConn(),
 		}
 
+		src, dst := helper.FlowAddr(net, transport)
 		if transport.Dst().String() == m.port {
-			stream.publicIp = net.Src().String()
-			stream.privateIp = net.Dst().String()
+			stm.clientAddr, stm.serverAddr = src, dst
 		} else {
-			stream.publicIp = net.Dst().String()
-			stream.privateIp = net.Src().String()
+			stm.clientAddr, stm.serverAddr = dst, src
 		}
 
+		m.source[streamID] = stm
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			stm.run()
+		}()
+	}
+	stm.refs++
+	m.mutex.Unlock()
+
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error(fmt.Sprintf("ERR : mongo stream panic, stream:%s,err:%v\n%s", streamID, err, debug.Stack()))
+		}
+		// 丢弃剩余数据，避免阻塞 assembler
+		tcpreader.DiscardBytesToEOF(buf)
+
 		m.mutex.Lock()
-		m.source[streamID] = stream
+		stm.refs--
+		if stm.refs == 0 {
+			close(stm.packets)
+			delete(m.source, streamID)
+		}
 		m.mutex.Unlock()
-		go stream.run()
-	}
+	}()
 
+	// 两个方向的第一个记录分别是 ClientHello 和 ServerHello
+	src, encrypted, _ := tlsdecrypt.Sniff(buf, stm.tls, transport.Dst().String() == m.port)
+	if encrypted && transport.Dst().String() == m.port {
+		m.logger.Info(fmt.Sprintf("stream:%s use TLS", streamID))
+	}
+	ps := helper.NewPeekStream(src)
 	for {
-		newPacket := m.newPacket(net, transport, buf)
+		newPacket := m.newPacket(net, transport, ps, encrypted)
 		if newPacket == nil {
 			return
 		}
-		m.source[streamID].packets <- newPacket
+		stm.packets <- newPacket
 	}
 }
 
-func (m *MongoDB) newPacket(net, transport gopacket.Flow, buf io.Reader) *packet {
+func (m *MongoDB) newPacket(net, transport gopacket.Flow, buf *helper.PeekStream, encrypted bool) *packet {
 	var packet *packet
 	var err error
-	packet, err = readStream(buf)
+	var start, skipped int64
+	for {
+		start = buf.Offset()
+		packet, err = readStream(buf)
+		if !errors.Is(err, ErrMessageLength) && !errors.Is(err, ErrUnknownOpCode) {
+			break
+		}
+		// 抓包从消息中间开始或者丢包时，逐字节向后找到下一个合理的消息头
+		if _, err = buf.Read(make([]byte, 1)); err != nil {
+			break
+		}
+		skipped++
+	}
+	if skipped > 0 {
+		m.logger.Debug(fmt.Sprintf("skip %d bytes to next mongo message header, stream:%s",
+			skipped,
+			net.Src().String()+":"+transport.Src().String()+":"+net.Dst().String()+":"+transport.Dst().String(),
+		))
+	}
 	if err != nil {
-		if err == io.EOF {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if encrypted {
+			m.logger.Warn(fmt.Sprintf("ERR : Could not decrypt TLS, stream:%s,err:%s",
+				net.Src().String()+":"+transport.Src().String()+":"+net.Dst().String()+":"+transport.Dst().String(),
+				err,
+			))
 			return nil
 		}
 		m.logger.Error(fmt.Sprintf("ERR:Unknown Stream: %s", err))
 		return nil
 	}
+	packet.timestamp = buf.Seen(start)
+
+	if packet.opCode == OP_COMPRESSED {
+		// 展开失败时保留 OP_COMPRESSED，解析时忽略该消息
+		if err := packet.decompress(); err != nil {
+			m.logger.Warn(fmt.Sprintf("ERR : Could not decompress, stream:%s,requestID:%d,err:%s",
+				net.Src().String()+":"+transport.Src().String()+":"+net.Dst().String()+":"+transport.Dst().String(),
+				packet.requestID,
+				err,
+			))
+		}
+	}
 
 	if transport.Dst().String() == m.port {
 		packet.isClientFlow = true
@@ -146,65 +234,111 @@ func (m *MongoDB) newPacket(net, transport gopacket.Flow, buf io.Reader) *packet
 	return packet
 }
 
-func readStream(r io.Reader) (*packet, error) {
+// peeker 可以预读的字节流，helper.PeekStream 和 bufio.Reader 都满足
+type peeker interface {
+	io.Reader
+	Peek(n int) ([]byte, error)
+}
 
-	var buf bytes.Buffer
-	p := &packet{}
+// checkHeader 检查消息头中的长度和 opCode 是否合理
+func checkHeader(header []byte) error {
+	length := int32(binary.LittleEndian.Uint32(header[0:4]))
+	if length < 16 || length > maxMessageSize {
+		return fmt.Errorf("%w: %d", ErrMessageLength, length)
+	}
+	opCode := int(int32(binary.LittleEndian.Uint32(header[12:16])))
+	if _, ok := opNames[opCode]; !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownOpCode, opCode)
+	}
+	return nil
+}
 
-	//header
-	header := make([]byte, 16)
-	if _, err := io.ReadFull(r, header); err != nil {
+// readStream 读取一个完整的消息，消息头不合理时返回 ErrMessageLength 或 ErrUnknownOpCode 且不消费数据，
+// 读到一半结束时返回 io.ErrUnexpectedEOF
+func readStream(r peeker) (*packet, error) {
+	header, err := r.Peek(16)
+	if err != nil {
+		if err == io.EOF && len(header) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := checkHeader(header); err != nil {
 		return nil, err
 	}
 
-	// message length
+	p := &packet{}
 	p.length = int(binary.LittleEndian.Uint32(header[0:4]) - 16)
 	p.requestID = binary.LittleEndian.Uint32(header[4:8])
 	p.responseTo = binary.LittleEndian.Uint32(header[8:12])
 	p.opCode = int(binary.LittleEndian.Uint32(header[12:]))
+	if _, err := io.ReadFull(r, make([]byte, 16)); err != nil {
+		return nil, err
+	}
 
-	if p.length != 0 {
-		n, err := io.CopyN(&buf, r, int64(p.length))
-		if err != nil {
-			return nil, err
-		}
-		if n != int64(p.length) {
-			return nil, fmt.Errorf("read payload length, incomplete")
+	// 按实际读到的数据增长，截断的流不会按消息头中的长度分配内存
+	var buf bytes.Buffer
+	if n, err := io.CopyN(&buf, r, int64(p.length)); err != nil {
+		if err == io.EOF && n < int64(p.length) {
+			return nil, io.ErrUnexpectedEOF
 		}
+		return nil, err
 	}
-	p.payload = bytes.NewReader(buf.Bytes())
+	p.data = buf.Bytes()
 	return p, nil
 }
 
-func (stm *stream) run() error {
+func (stm *stream) run() {
 	for {
 		select {
 		case packet, ok := <-stm.packets:
 			if !ok {
-				return nil
+				stm.flush()
+				return
 			}
-			if packet.isClientFlow {
-				stm.resolveClientPacket(packet)
-			} else {
-				// stm.resolveServerPacket(packet)
-			}
-		case <-time.After(time.Minute * 5): // 5分钟没有数据包，则认为连接断开
-			return ErrTimeOut
+			stm.resolve(packet)
+		case <-time.After(time.Minute * 5): // 5分钟没有数据包，输出等待响应的请求，继续等待直到连接关闭
+			stm.flush()
+		}
+	}
+}
+
+// resolve 解析一个消息，解析中 panic 时只丢弃该消息，连接继续解析
+func (stm *stream) resolve(packet *packet) {
+	defer func() {
+		if err := recover(); err != nil {
+			stm.logger.Error(fmt.Sprintf("ERR : %s panic, stream:%s,requestID:%d,err:%v\n%s",
+				OpName(packet.opCode), stm.id, packet.requestID, err, debug.Stack()))
 		}
+	}()
+	if packet.isClientFlow {
+		stm.resolveClientPacket(packet)
+	} else {
+		stm.resolveServerPacket(packet)
 	}
 }
 
 func (stm *stream) resolveClientPacket(packet *packet) {
 	var msg string
+	e := &event.Event{
+		Protocol:   event.ProtocolMongo,
+		ClientAddr: stm.clientAddr,
+		ServerAddr: stm.serverAddr,
+		ConnID:     stm.id,
+		Command:    OpName(packet.opCode),
+		Timestamp:  packet.timestamp,
+		BytesIn:    packet.length + 16,
+		Conn:       stm.conn,
+	}
+	var err error
+	r := newMsgReader(packet.data)
 	switch packet.opCode {
 	case OP_UPDATE:
-		zero := ReadInt32(packet.payload)
-		fullCollectionName := ReadString(packet.payload)
-		flags := ReadInt32(packet.payload)
-		selector := ReadBson2Json(packet.payload)
-		update := ReadBson2Json(packet.payload)
-		_ = zero
-		_ = flags
+		r.Skip(4) // ZERO
+		fullCollectionName := r.CString()
+		r.Skip(4) // flags
+		selector := r.JSON()
+		update := r.JSON()
 
 		msg = fmt.Sprintf(" [OP_UPDATE] [coll:%s] %v %v",
 			fullCollectionName,
@@ -213,10 +347,9 @@ func (stm *stream) resolveClientPacket(packet *packet) {
 		)
 
 	case OP_INSERT:
-		flags := ReadInt32(packet.payload)
-		fullCollectionName := ReadString(packet.payload)
-		command := ReadBson2Json(packet.payload)
-		_ = flags
+		r.Skip(4) // flags
+		fullCollectionName := r.CString()
+		command := r.JSON()
 
 		msg = fmt.Sprintf(" [OP_INSERT] [coll:%s] %v",
 			fullCollectionName,
@@ -224,34 +357,33 @@ func (stm *stream) resolveClientPacket(packet *packet) {
 		)
 
 	case OP_QUERY:
-		flags := ReadInt32(packet.payload)
-		fullCollectionName := ReadString(packet.payload)
-		numberToSkip := ReadInt32(packet.payload)
-		numberToReturn := ReadInt32(packet.payload)
-		_ = flags
-		_ = numberToSkip
-		_ = numberToReturn
-
-		command := ReadBson2Json(packet.payload)
-		selector := ReadBson2Json(packet.payload)
-
-		msg = fmt.Sprintf(" [OP_QUERY] [coll:%s] %v %v",
-			fullCollectionName,
-			command,
-			selector,
-		)
-
-		// 如果selector是isMaster命令
-		if strings.Contains(msg, "isMaster") {
-			msg = ""
+		r.Skip(4) // flags
+		fullCollectionName := r.CString()
+		r.Skip(8) // numberToSkip, numberToReturn
+
+		query := r.Document()
+		selector := r.JSON()
+
+		if database, ok := strings.CutSuffix(fullCollectionName, ".$cmd"); ok && query != nil {
+			// 发往 $cmd 的是命令
+			query = unwrapQuery(query)
+			e.Command = commandName(query)
+			e.Database = database
+			msg = renderCommand(database, query, nil)
+		} else {
+			msg = fmt.Sprintf(" [OP_QUERY] [coll:%s] %v %v",
+				fullCollectionName,
+				extJSON(bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: query}),
+				selector,
+			)
 		}
 
 	case OP_COMMAND:
-		database := ReadString(packet.payload)
-		commandName := ReadString(packet.payload)
-		metaData := ReadBson2Json(packet.payload)
-		commandArgs := ReadBson2Json(packet.payload)
-		inputDocs := ReadBson2Json(packet.payload)
+		database := r.CString()
+		commandName := r.CString()
+		metaData := r.JSON()
+		commandArgs := r.JSON()
+		inputDocs := r.JSON()
 
 		msg = fmt.Sprintf(" [OP_COMMAND] [DB:%s] [Cmd:%s] %v %v %v",
 			database,
@@ -262,11 +394,10 @@ func (stm *stream) resolveClientPacket(packet *packet) {
 		)
 
 	case OP_GET_MORE:
-		zero := ReadInt32(packet.payload)
-		fullCollectionName := ReadString(packet.payload)
-		numberToReturn := ReadInt32(packet.payload)
-		cursorId := ReadInt64(packet.payload)
-		_ = zero
+		r.Skip(4) // ZERO
+		fullCollectionName := r.CString()
+		numberToReturn := r.Int32()
+		cursorId := r.Int64()
 
 		msg = fmt.Sprintf(" [OP_GET_MORE] [coll:%s] [num of reply:%v] [cursor:%v]",
 			fullCollectionName,
@@ -275,12 +406,10 @@ func (stm *stream) resolveClientPacket(packet *packet) {
 		)
 
 	case OP_DELETE:
-		zero := ReadInt32(packet.payload)
-		fullCollectionName := ReadString(packet.payload)
-		flags := ReadInt32(packet.payload)
-		selector := ReadBson2Json(packet.payload)
-		_ = zero
-		_ = flags
+		r.Skip(4) // ZERO
+		fullCollectionName := r.CString()
+		r.Skip(4) // flags
+		selector := r.JSON()
 
 		msg = fmt.Sprintf(" [OP_DELETE] [coll:%s] %v",
 			fullCollectionName,
@@ -288,23 +417,37 @@ func (stm *stream) resolveClientPacket(packet *packet) {
 		)
 
 	case OP_MSG:
-		// stm.logger.Warn(fmt.Sprintf("OP_MSG: %+v", packet))
-		payload, err := io.ReadAll(packet.payload)
-		if err != nil {
-			stm.logger.Error(fmt.Sprintf("read payload error: %v", err))
-			return
+		var opMsg *OpMsg
+		if opMsg, err = parseSections(packet.data); err != nil {
+			break
+		}
+		if name := commandName(opMsg.Body); len(name) != 0 {
+			e.Command = name
 		}
-		_, msg = parseSections(payload)
+		e.Database, _ = opMsg.Body.Lookup("$db").StringValueOK()
+		msg = renderCommand(e.Database, opMsg.Body, opMsg.Sequences)
 	default:
 		return
 	}
 
+	if err == nil {
+		err = r.Err()
+	}
+	if err != nil {
+		stm.logger.WithTime(packet.timestamp).Warn(fmt.Sprintf("ERR : %s, stream:%s,requestID:%d,err:%s",
+			OpName(packet.opCode), stm.id, packet.requestID, err))
+		return
+	}
 	if len(msg) == 0 {
 		return
 	}
-	stm.logger.Info(fmt.Sprintf("%s->%s:%s", stm.publicIp, stm.privateIp, msg))
+	e.Statement = strings.TrimSpace(msg)
+	stm.request(packet, e)
 }
 
-// func (stm *stream) resolveServerPacket(packet *packet) {
-// 	return
-// }
+func (stm *stream) resolveServerPacket(packet *packet) {
+	switch packet.opCode {
+	case OP_MSG, OP_REPLY, OP_COMMANDREPLY:
+		stm.resolveReply(packet)
+	}
+}
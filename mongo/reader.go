@@ -0,0 +1,133 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var ErrMalformMessage = errors.New("malformed message")
+
+// msgReader 按顺序读取消息负载中的字段，越界或文档不合法时记录 ErrMalformMessage 并返回零值
+type msgReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newMsgReader(data []byte) *msgReader {
+	return &msgReader{data: data}
+}
+
+func (r *msgReader) Len() int {
+	return len(r.data) - r.pos
+}
+
+func (r *msgReader) Err() error {
+	return r.err
+}
+
+func (r *msgReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s", ErrMalformMessage, fmt.Sprintf(format, args...))
+	}
+	r.pos = len(r.data)
+}
+
+func (r *msgReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.Len() < n {
+		r.fail("need %d bytes at %d, only %d", n, r.pos, r.Len())
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *msgReader) Skip(n int) {
+	r.next(n)
+}
+
+func (r *msgReader) Byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *msgReader) Uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *msgReader) Int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *msgReader) Int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// CString 读取以 0 结尾的字符串
+func (r *msgReader) CString() string {
+	if r.err != nil {
+		return ""
+	}
+	idx := bytes.IndexByte(r.data[r.pos:], 0)
+	if idx == -1 {
+		r.fail("unterminated cstring at %d", r.pos)
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+idx])
+	r.pos += idx + 1
+	return s
+}
+
+// Document 读取一个校验过的 BSON 文档
+func (r *msgReader) Document() bson.Raw {
+	if r.err != nil {
+		return nil
+	}
+	if r.Len() < 4 {
+		r.fail("need document length at %d, only %d bytes", r.pos, r.Len())
+		return nil
+	}
+	size := int(int32(binary.LittleEndian.Uint32(r.data[r.pos:])))
+	if size < 5 || size > r.Len() {
+		r.fail("document length %d at %d, only %d bytes", size, r.pos, r.Len())
+		return nil
+	}
+	doc := bson.Raw(r.data[r.pos : r.pos+size])
+	if err := doc.Validate(); err != nil {
+		r.fail("document at %d: %v", r.pos, err)
+		return nil
+	}
+	r.pos += size
+	return doc
+}
+
+// JSON 读取一个 BSON 文档并按原始顺序输出为 relaxed Extended JSON，已经读完时返回空字符串(可选的文档)
+func (r *msgReader) JSON() string {
+	if r.Len() == 0 {
+		return ""
+	}
+	doc := r.Document()
+	if doc == nil {
+		return ""
+	}
+	return extJSON(bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: doc})
+}
@@ -0,0 +1,134 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// message 生成带消息头的完整消息
+func message(requestID, responseTo uint32, opCode int, body []byte) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(16+len(body)))
+	data = binary.LittleEndian.AppendUint32(data, requestID)
+	data = binary.LittleEndian.AppendUint32(data, responseTo)
+	data = binary.LittleEndian.AppendUint32(data, uint32(opCode))
+	return append(data, body...)
+}
+
+func TestReadStream(t *testing.T) {
+	msg := opMsg(t, 0, bson.D{{Key: "find", Value: "orders"}})
+	for _, c := range []struct {
+		data []byte
+		err  error
+	}{
+		{message(1, 0, OP_MSG, msg), nil},
+		{nil, io.EOF},
+		{message(1, 0, OP_MSG, msg)[:10], io.ErrUnexpectedEOF},
+		{message(1, 0, OP_MSG, msg)[:30], io.ErrUnexpectedEOF},
+		{message(1, 0, 9999, msg), ErrUnknownOpCode},
+		{append(binary.LittleEndian.AppendUint32(nil, 0xffffffff), message(1, 0, OP_MSG, nil)[4:]...), ErrMessageLength},
+		{append(binary.LittleEndian.AppendUint32(nil, maxMessageSize+1), message(1, 0, OP_MSG, nil)[4:]...), ErrMessageLength},
+	} {
+		r := bufio.NewReader(bytes.NewReader(c.data))
+		p, err := readStream(r)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("expect %v, got %v", c.err, err)
+		}
+		if err == nil && (p.requestID != 1 || p.opCode != OP_MSG || !bytes.Equal(p.data, msg)) {
+			t.Fatalf("unexpected packet %+v", p)
+		}
+		// 消息头不合理时不消费数据
+		if errors.Is(err, ErrUnknownOpCode) || errors.Is(err, ErrMessageLength) {
+			if r.Buffered() != len(c.data) {
+				t.Fatalf("header consumed: %d", r.Buffered())
+			}
+		}
+	}
+}
+
+func TestMsgReader(t *testing.T) {
+	doc, _ := bson.Marshal(bson.D{{Key: "a", Value: 1}})
+	r := newMsgReader(append([]byte("shop.orders\x00"), doc...))
+	if r.CString() != "shop.orders" || r.JSON() != `{"a":1}` || r.JSON() != "" || r.Err() != nil {
+		t.Fatalf("unexpected read: %v", r.Err())
+	}
+
+	for _, c := range []struct {
+		data []byte
+		read func(r *msgReader)
+	}{
+		{[]byte("no terminator"), func(r *msgReader) { r.CString() }},
+		{[]byte{1, 2, 3}, func(r *msgReader) { r.Int32() }},
+		{[]byte{0xff, 0xff, 0xff, 0x7f, 0}, func(r *msgReader) { r.JSON() }},
+		{[]byte{6, 0, 0, 0, 0x02, 0}, func(r *msgReader) { r.JSON() }}, // 元素没有结尾
+		{doc[:len(doc)-1], func(r *msgReader) { r.JSON() }},
+	} {
+		r := newMsgReader(c.data)
+		if c.read(r); !errors.Is(r.Err(), ErrMalformMessage) || r.Len() != 0 {
+			t.Fatalf("expect malformed: %x %v", c.data, r.Err())
+		}
+	}
+}
+
+func FuzzParseSections(f *testing.F) {
+	f.Add(opMsg(f, 0, bson.D{{Key: "find", Value: "orders"}, {Key: "$db", Value: "shop"}}))
+	seq := opMsg(f, msgChecksumPresent, bson.D{{Key: "insert", Value: "orders"}})
+	doc, _ := bson.Marshal(bson.D{{Key: "_id", Value: 1}})
+	seq = append(seq, 1)
+	seq = binary.LittleEndian.AppendUint32(seq, uint32(4+len("documents\x00")+len(doc)))
+	seq = append(append(append(seq, "documents\x00"...), doc...), 0, 0, 0, 0)
+	f.Add(seq)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		if msg, err := parseSections(payload); err == nil {
+			renderCommand("shop", msg.Body, msg.Sequences)
+		}
+	})
+}
+
+func FuzzReadStream(f *testing.F) {
+	msg := message(1, 0, OP_MSG, opMsg(f, 0, bson.D{{Key: "find", Value: "orders"}}))
+	reply := message(2, 1, OP_MSG, opMsg(f, 0, bson.D{{Key: "ok", Value: 1.0}}))
+	query := message(3, 0, OP_QUERY, append(append([]byte{0, 0, 0, 0}, "shop.$cmd\x00"...), make([]byte, 8)...))
+	f.Add(append(msg, reply...))
+	f.Add(append(append([]byte("garbage"), query...), msg[:20]...))
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var sink sliceSink
+		stm := &stream{logger: logger, sink: &sink}
+		r := bufio.NewReader(bytes.NewReader(data))
+		for {
+			p, err := readStream(r)
+			if errors.Is(err, ErrMessageLength) || errors.Is(err, ErrUnknownOpCode) {
+				r.Discard(1)
+				continue
+			}
+			if err != nil {
+				break
+			}
+			if p.length != len(p.data) || p.length > maxMessageSize {
+				t.Fatalf("unexpected length %d, data %d", p.length, len(p.data))
+			}
+			if p.opCode == OP_COMPRESSED {
+				p.decompress()
+			}
+			// 不经过 resolve 的 recover，解析中的 panic 直接让测试失败
+			p.isClientFlow = p.responseTo == 0
+			if p.isClientFlow {
+				stm.resolveClientPacket(p)
+			} else {
+				stm.resolveServerPacket(p)
+			}
+		}
+		stm.flush()
+	})
+}
@@ -0,0 +1,230 @@
+package mongo
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/JacksonChan-X/sql-sniffer/authfail"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	maxPending     = 1024 // 每个连接最多等待响应的请求数，超过时先输出最早的请求
+	maxWriteErrors = 10   // 每个响应最多保留的 writeErrors
+)
+
+// OP_MSG 的 flagBits
+const (
+	msgChecksumPresent = 1 << 0
+	msgMoreToCome      = 1 << 1
+)
+
+// OP_REPLY 的 responseFlags
+const replyQueryFailure = 1 << 1
+
+// request 等待响应的请求
+type request struct {
+	event   *event.Event
+	command bool // 响应是命令的结果文档，而不是 OP_QUERY 查询到的文档
+	auth    bool // 认证命令，失败时统计
+	hello   bool // 连接上第一个 hello，响应中有服务端信息
+}
+
+// expectReply 判断请求是否有响应
+func expectReply(p *packet) bool {
+	switch p.opCode {
+	case OP_QUERY, OP_GET_MORE, OP_COMMAND:
+		return true
+	case OP_MSG:
+		return len(p.data) >= 4 && binary.LittleEndian.Uint32(p.data[0:4])&msgMoreToCome == 0
+	}
+	return false
+}
+
+// request 有响应的请求等待响应后输出，其余直接输出
+func (stm *stream) request(p *packet, e *event.Event) {
+	doc := commandDocument(p)
+	if doc != nil && stm.conn != nil && isHello(doc) {
+		// 连接信息只取第一个 hello，之后的 hello/isMaster 是驱动和监控线程的心跳，不输出
+		return
+	}
+	if !expectReply(p) {
+		stm.sink.Emit(e)
+		return
+	}
+	req := &request{event: e, command: p.opCode != OP_QUERY && p.opCode != OP_GET_MORE}
+	if doc != nil {
+		req.command = true
+		if user, ok := stm.authCommand(doc); ok {
+			e.User, req.auth = user, true
+		}
+		if stm.clientHello(doc) {
+			e.Conn, req.hello = stm.conn, true
+		}
+	}
+	if stm.pending == nil {
+		stm.pending = make(map[uint32]*request)
+	}
+	if len(stm.pending) >= maxPending {
+		stm.flushOldest()
+	}
+	stm.pending[p.requestID] = req
+}
+
+// flushOldest 输出最早的一个没有响应的请求
+func (stm *stream) flushOldest() {
+	var oldest uint32
+	var found *request
+	for id, req := range stm.pending {
+		if found == nil || req.event.Timestamp.Before(found.event.Timestamp) {
+			oldest, found = id, req
+		}
+	}
+	delete(stm.pending, oldest)
+	stm.sink.Emit(found.event)
+}
+
+// flush 连接结束时按请求顺序输出全部没有响应的请求
+func (stm *stream) flush() {
+	reqs := make([]*request, 0, len(stm.pending))
+	for _, req := range stm.pending {
+		reqs = append(reqs, req)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].event.Timestamp.Before(reqs[j].event.Timestamp)
+	})
+	for _, req := range reqs {
+		stm.sink.Emit(req.event)
+	}
+	stm.pending = nil
+}
+
+// resolveReply 按 responseTo 找到请求，填充响应后输出
+func (stm *stream) resolveReply(p *packet) {
+	req, ok := stm.pending[p.responseTo]
+	if !ok {
+		return
+	}
+	delete(stm.pending, p.responseTo)
+
+	e := req.event
+	e.Latency = p.timestamp.Sub(e.Timestamp)
+	e.BytesOut = p.length + 16
+	switch {
+	case p.opCode == OP_REPLY && !req.command:
+		fillQueryReply(e, p)
+	default:
+		if doc := commandDocument(p); doc != nil {
+			fillReply(e, doc)
+			if req.hello {
+				stm.serverHello(doc)
+				e.Conn = stm.conn
+			}
+		}
+	}
+	stm.sink.Emit(e)
+
+	if req.auth && e.Result == event.ResultError {
+		for _, alert := range authfail.Observe(e) {
+			stm.sink.Emit(alert)
+		}
+	}
+}
+
+// fillReply 从命令的响应文档中取出结果
+func fillReply(e *event.Event, doc bson.Raw) {
+	r := &event.MongoReply{OK: replyOK(doc)}
+	e.MongoReply = r
+	r.CodeName, _ = doc.Lookup("codeName").StringValueOK()
+	r.N, _ = doc.Lookup("n").AsInt64OK()
+	r.NModified, _ = doc.Lookup("nModified").AsInt64OK()
+
+	if cursor, ok := doc.Lookup("cursor").DocumentOK(); ok {
+		r.CursorID, _ = cursor.Lookup("id").AsInt64OK()
+		for _, key := range []string{"firstBatch", "nextBatch"} {
+			if batch, ok := cursor.Lookup(key).ArrayOK(); ok {
+				values, _ := batch.Values()
+				r.Batch = len(values)
+			}
+		}
+	}
+	if writeErrors, ok := doc.Lookup("writeErrors").ArrayOK(); ok {
+		values, _ := writeErrors.Values()
+		for _, v := range values {
+			d, ok := v.DocumentOK()
+			if !ok || len(r.WriteErrors) >= maxWriteErrors {
+				continue
+			}
+			var we event.MongoWriteError
+			index, _ := d.Lookup("index").AsInt64OK()
+			code, _ := d.Lookup("code").AsInt64OK()
+			we.Index, we.Code = int(index), int(code)
+			we.ErrMsg, _ = d.Lookup("errmsg").StringValueOK()
+			r.WriteErrors = append(r.WriteErrors, we)
+		}
+	}
+
+	switch {
+	case !r.OK:
+		code, _ := doc.Lookup("code").AsInt64OK()
+		e.Result = event.ResultError
+		e.ErrorCode = int(code)
+		e.ErrorMessage, _ = doc.Lookup("errmsg").StringValueOK()
+	case len(r.WriteErrors) > 0:
+		e.Result = event.ResultError
+		e.ErrorCode = r.WriteErrors[0].Code
+		e.ErrorMessage = r.WriteErrors[0].ErrMsg
+	case doc.Lookup("writeConcernError").Type != 0:
+		wce, _ := doc.Lookup("writeConcernError").DocumentOK()
+		code, _ := wce.Lookup("code").AsInt64OK()
+		e.Result = event.ResultError
+		e.ErrorCode = int(code)
+		e.ErrorMessage, _ = wce.Lookup("errmsg").StringValueOK()
+	case doc.Lookup("cursor").Type != 0:
+		e.Result = event.ResultResultSet
+		e.Rows = int64(r.Batch)
+	default:
+		e.Result = event.ResultOK
+		e.AffectedRows = r.N
+		if r.NModified != 0 {
+			e.AffectedRows = r.NModified
+		}
+	}
+}
+
+// fillQueryReply OP_QUERY、OP_GET_MORE 查询的 OP_REPLY: 游标和返回的文档数，查询失败时第一个文档是 $err
+func fillQueryReply(e *event.Event, p *packet) {
+	if len(p.data) < 20 {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(p.data[0:4])
+	r := &event.MongoReply{
+		OK:       flags&replyQueryFailure == 0,
+		CursorID: int64(binary.LittleEndian.Uint64(p.data[4:12])),
+		Batch:    int(int32(binary.LittleEndian.Uint32(p.data[16:20]))),
+	}
+	e.MongoReply = r
+	if r.OK {
+		e.Result = event.ResultResultSet
+		e.Rows = int64(r.Batch)
+		return
+	}
+	e.Result = event.ResultError
+	if doc := commandDocument(p); doc != nil {
+		code, _ := doc.Lookup("code").AsInt64OK()
+		e.ErrorCode = int(code)
+		e.ErrorMessage, _ = doc.Lookup("$err").StringValueOK()
+	}
+}
+
+// replyOK 响应中的 ok 字段，可能是 double、int 或 bool
+func replyOK(doc bson.Raw) bool {
+	v := doc.Lookup("ok")
+	if b, ok := v.BooleanOK(); ok {
+		return b
+	}
+	n, ok := v.AsInt64OK()
+	return !ok || n != 0
+}
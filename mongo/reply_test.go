@@ -0,0 +1,67 @@
+package mongo
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type sliceSink []*event.Event
+
+func (s *sliceSink) Emit(e *event.Event) {
+	*s = append(*s, e)
+}
+
+// opMsg 生成只有一个类型 0 文档的 OP_MSG 负载
+func opMsg(t testing.TB, flags uint32, doc any) []byte {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := binary.LittleEndian.AppendUint32(nil, flags)
+	return append(append(data, 0), b...)
+}
+
+func TestResolveReply(t *testing.T) {
+	var sink sliceSink
+	stm := &stream{sink: &sink}
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	send := func(requestID uint32, cmd bson.D, at time.Duration) {
+		data := opMsg(t, 0, cmd)
+		p := &packet{isClientFlow: true, requestID: requestID, opCode: OP_MSG, data: data, length: len(data), timestamp: t0.Add(at)}
+		stm.request(p, &event.Event{Command: cmd[0].Key, Timestamp: p.timestamp})
+	}
+	reply := func(responseTo uint32, doc bson.D, at time.Duration) {
+		data := opMsg(t, 0, doc)
+		stm.resolveServerPacket(&packet{responseTo: responseTo, opCode: OP_MSG, data: data, length: len(data), timestamp: t0.Add(at)})
+	}
+
+	send(1, bson.D{{Key: "aggregate", Value: "orders"}, {Key: "$db", Value: "shop"}}, 0)
+	send(2, bson.D{{Key: "insert", Value: "orders"}, {Key: "$db", Value: "shop"}}, time.Second)
+	reply(2, bson.D{{Key: "n", Value: 1}, {Key: "writeErrors", Value: bson.A{
+		bson.D{{Key: "index", Value: 1}, {Key: "code", Value: 11000}, {Key: "errmsg", Value: "E11000"}},
+	}}, {Key: "ok", Value: 1.0}}, 2*time.Second)
+	reply(1, bson.D{{Key: "cursor", Value: bson.D{
+		{Key: "firstBatch", Value: bson.A{bson.D{}, bson.D{}}}, {Key: "id", Value: int64(42)},
+	}}, {Key: "ok", Value: 1.0}}, 3*time.Second)
+	send(3, bson.D{{Key: "drop", Value: "nope"}}, 4*time.Second)
+	stm.flush()
+
+	if len(sink) != 3 {
+		t.Fatalf("unexpected events: %+v", sink)
+	}
+	if e := sink[0]; e.Command != "insert" || e.Result != event.ResultError || e.ErrorCode != 11000 || e.Latency != time.Second {
+		t.Fatalf("unexpected insert: %+v", e)
+	}
+	if e := sink[1]; e.Result != event.ResultResultSet || e.Rows != 2 || e.MongoReply.CursorID != 42 || e.Latency != 3*time.Second {
+		t.Fatalf("unexpected aggregate: %+v %+v", e, e.MongoReply)
+	}
+	if e := sink[2]; e.Command != "drop" || e.Result != "" {
+		t.Fatalf("unexpected drop: %+v", e)
+	}
+}
@@ -0,0 +1,323 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// internalFields 驱动自动附加的字段，不影响命令的语义，渲染时忽略
+var internalFields = map[string]bool{
+	"$db":                  true,
+	"lsid":                 true,
+	"$clusterTime":         true,
+	"txnNumber":            true,
+	"autocommit":           true,
+	"startTransaction":     true,
+	"$readPreference":      true,
+	"apiVersion":           true,
+	"apiStrict":            true,
+	"apiDeprecationErrors": true,
+}
+
+// identifier 可以直接写成 db.<name> 的集合名
+var identifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// renderCommand 把命令渲染成可以直接粘贴到 mongosh 执行的语句，
+// 文档按原始顺序输出为 relaxed Extended JSON，seqs 为 OP_MSG 类型 1 的文档序列。
+// 语句中的 db 指命令所在的数据库 database，admin 上的其他命令用 adminCommand
+func renderCommand(database string, cmd bson.Raw, seqs map[string][]bson.Raw) string {
+	elems, err := cmd.Elements()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	name, value := elems[0].Key(), elems[0].Value()
+	c := &shellCommand{cmd: cmd, seqs: seqs}
+	if coll, ok := value.StringValueOK(); ok {
+		c.coll = collection(coll)
+	}
+
+	var stmt string
+	switch {
+	case c.coll == "":
+		if name == "aggregate" {
+			// {aggregate: 1} 是数据库级的聚合，如 $currentOp
+			stmt = "db" + call("aggregate", c.json("pipeline"), c.options("allowDiskUse", "maxTimeMS", "comment"))
+		}
+	case name == "find":
+		stmt = c.find()
+	case name == "aggregate":
+		stmt = c.coll + call("aggregate", c.json("pipeline"), c.options("allowDiskUse", "collation", "hint", "let", "maxTimeMS", "comment"))
+	case name == "count":
+		stmt = c.coll + call("count", c.json("query"), c.options("limit", "skip", "hint", "collation"))
+	case name == "distinct":
+		stmt = c.coll + call("distinct", c.json("key"), c.json("query"), c.options("collation"))
+	case name == "insert":
+		stmt = c.insert()
+	case name == "update":
+		stmt = c.update()
+	case name == "delete":
+		stmt = c.delete()
+	case name == "findAndModify" || name == "findandmodify":
+		stmt = c.findAndModify()
+	case name == "createIndexes":
+		stmt = c.createIndexes()
+	case name == "dropIndexes" || name == "deleteIndexes":
+		if index, _ := cmd.Lookup("index").StringValueOK(); index == "*" {
+			stmt = c.coll + ".dropIndexes()"
+		} else {
+			stmt = c.coll + call("dropIndex", c.json("index"))
+		}
+	case name == "drop":
+		stmt = c.coll + ".drop()"
+	}
+	if stmt != "" {
+		return stmt
+	}
+
+	// 其余命令原样通过 runCommand 执行
+	if database == "admin" {
+		return "db.adminCommand(" + c.command() + ")"
+	}
+	return "db.runCommand(" + c.command() + ")"
+}
+
+type shellCommand struct {
+	cmd  bson.Raw
+	seqs map[string][]bson.Raw
+	coll string // db.<集合>
+}
+
+func (c *shellCommand) find() string {
+	var b strings.Builder
+	b.WriteString(c.coll + call("find", c.json("filter"), c.json("projection")))
+	for _, key := range []string{"sort", "skip", "limit", "hint", "collation", "maxTimeMS", "comment"} {
+		if v := c.json(key); v != "" {
+			b.WriteString(fmt.Sprintf(".%s(%s)", key, v))
+		}
+	}
+	return b.String()
+}
+
+func (c *shellCommand) insert() string {
+	docs := c.documents("documents")
+	if len(docs) == 1 {
+		return c.coll + call("insertOne", extJSON(docs[0]))
+	}
+	var options string
+	if ordered, ok := c.cmd.Lookup("ordered").BooleanOK(); ok && !ordered {
+		options = `{"ordered":false}`
+	}
+	return c.coll + call("insertMany", jsonArray(docs), options)
+}
+
+func (c *shellCommand) update() string {
+	var stmts []string
+	for _, v := range c.documents("updates") {
+		doc, ok := v.DocumentOK()
+		if !ok {
+			continue
+		}
+		u := &shellCommand{cmd: doc}
+		method := "replaceOne"
+		if isUpdate(u.cmd.Lookup("u")) {
+			method = "updateOne"
+			if multi, _ := u.cmd.Lookup("multi").BooleanOK(); multi {
+				method = "updateMany"
+			}
+		}
+		stmts = append(stmts, c.coll+call(method, u.json("q"), u.json("u"), u.options("upsert", "arrayFilters", "hint", "collation")))
+	}
+	return strings.Join(stmts, "; ")
+}
+
+func (c *shellCommand) delete() string {
+	var stmts []string
+	for _, v := range c.documents("deletes") {
+		doc, ok := v.DocumentOK()
+		if !ok {
+			continue
+		}
+		d := &shellCommand{cmd: doc}
+		method := "deleteMany"
+		if limit, _ := d.cmd.Lookup("limit").AsInt64OK(); limit == 1 {
+			method = "deleteOne"
+		}
+		stmts = append(stmts, c.coll+call(method, d.json("q"), d.options("hint", "collation")))
+	}
+	return strings.Join(stmts, "; ")
+}
+
+func (c *shellCommand) findAndModify() string {
+	remove, _ := c.cmd.Lookup("remove").BooleanOK()
+	returnNew, _ := c.cmd.Lookup("new").BooleanOK()
+	// findAndModify 的字段和 mongosh 选项的对应关系，按 mongosh 文档中的顺序
+	options := bson.D{}
+	for _, f := range [][2]string{{"fields", "projection"}, {"sort", "sort"}, {"upsert", "upsert"}, {"new", "returnDocument"},
+		{"arrayFilters", "arrayFilters"}, {"hint", "hint"}, {"collation", "collation"}} {
+		v, err := c.cmd.LookupErr(f[0])
+		switch {
+		case err != nil:
+		case f[0] == "new":
+			if returnNew && !remove {
+				options = append(options, bson.E{Key: f[1], Value: "after"})
+			}
+		default:
+			options = append(options, bson.E{Key: f[1], Value: v})
+		}
+	}
+
+	if remove {
+		return c.coll + call("findOneAndDelete", c.json("query"), document(options))
+	}
+	method := "findOneAndReplace"
+	if isUpdate(c.cmd.Lookup("update")) {
+		method = "findOneAndUpdate"
+	}
+	return c.coll + call(method, c.json("query"), c.json("update"), document(options))
+}
+
+func (c *shellCommand) createIndexes() string {
+	var stmts []string
+	for _, v := range c.documents("indexes") {
+		index, ok := v.DocumentOK()
+		if !ok {
+			continue
+		}
+		elems, _ := index.Elements()
+		options := bson.D{}
+		for _, elem := range elems {
+			if key := elem.Key(); key != "key" && key != "v" && key != "ns" {
+				options = append(options, bson.E{Key: key, Value: elem.Value()})
+			}
+		}
+		stmts = append(stmts, c.coll+call("createIndex", extJSON(index.Lookup("key")), document(options)))
+	}
+	return strings.Join(stmts, "; ")
+}
+
+// command 去掉内部字段并合并文档序列后的命令文档
+func (c *shellCommand) command() string {
+	elems, _ := c.cmd.Elements()
+	doc := bson.D{}
+	for _, elem := range elems {
+		if !internalFields[elem.Key()] {
+			doc = append(doc, bson.E{Key: elem.Key(), Value: elem.Value()})
+		}
+	}
+	ids := make([]string, 0, len(c.seqs))
+	for id := range c.seqs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		doc = append(doc, bson.E{Key: id, Value: c.seqs[id]})
+	}
+	return document(doc)
+}
+
+// json 返回字段的 Extended JSON，字段不存在时为空字符串
+func (c *shellCommand) json(key string) string {
+	v, err := c.cmd.LookupErr(key)
+	if err != nil {
+		return ""
+	}
+	return extJSON(v)
+}
+
+// options 由存在的字段组成选项文档，都不存在时为空字符串
+func (c *shellCommand) options(keys ...string) string {
+	options := bson.D{}
+	for _, key := range keys {
+		if v, err := c.cmd.LookupErr(key); err == nil {
+			options = append(options, bson.E{Key: key, Value: v})
+		}
+	}
+	return document(options)
+}
+
+// documents 返回文档序列中的文档，没有时取命令中同名的数组
+func (c *shellCommand) documents(key string) []bson.RawValue {
+	if docs, ok := c.seqs[key]; ok {
+		values := make([]bson.RawValue, 0, len(docs))
+		for _, doc := range docs {
+			values = append(values, bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: doc})
+		}
+		return values
+	}
+	array, ok := c.cmd.Lookup(key).ArrayOK()
+	if !ok {
+		return nil
+	}
+	values, _ := array.Values()
+	return values
+}
+
+// isUpdate 更新是操作符文档或聚合管道，否则是替换文档
+func isUpdate(v bson.RawValue) bool {
+	if v.Type == bson.TypeArray {
+		return true
+	}
+	doc, ok := v.DocumentOK()
+	if !ok {
+		return false
+	}
+	elems, _ := doc.Elements()
+	return len(elems) > 0 && strings.HasPrefix(elems[0].Key(), "$")
+}
+
+// collection 返回集合的 mongosh 写法，名字不是合法标识符时用 getCollection
+func collection(name string) string {
+	if identifier.MatchString(name) {
+		return "db." + name
+	}
+	quoted, _ := json.Marshal(name)
+	return "db.getCollection(" + string(quoted) + ")"
+}
+
+// call 生成方法调用，去掉末尾为空的参数，中间为空的参数补为 {}
+func call(method string, args ...string) string {
+	for len(args) > 0 && args[len(args)-1] == "" {
+		args = args[:len(args)-1]
+	}
+	for i, arg := range args {
+		if arg == "" {
+			args[i] = "{}"
+		}
+	}
+	return "." + method + "(" + strings.Join(args, ", ") + ")"
+}
+
+// document 输出文档的 Extended JSON，空文档为空字符串
+func document(doc bson.D) string {
+	if len(doc) == 0 {
+		return ""
+	}
+	b, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Sprintf("{\"error\":%q}", err.Error())
+	}
+	return string(b)
+}
+
+func jsonArray(values []bson.RawValue) string {
+	items := make([]string, 0, len(values))
+	for _, v := range values {
+		items = append(items, extJSON(v))
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// extJSON 输出任意 BSON 值的 relaxed Extended JSON
+func extJSON(v bson.RawValue) string {
+	// MarshalExtJSON 只接受文档，把值包装在空字段名下再去掉外层
+	b, err := bson.MarshalExtJSON(bson.D{{Key: "", Value: v}}, false, false)
+	if err != nil {
+		return fmt.Sprintf("{\"error\":%q}", err.Error())
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(b), `{"":`), "}")
+}
@@ -0,0 +1,106 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRenderCommand(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a1b2c3d4e5f60718293a4b")
+	raw := func(doc any) bson.Raw {
+		b, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for _, c := range []struct {
+		cmd  bson.D
+		seqs map[string][]bson.Raw
+		db   string
+		want string
+	}{
+		{
+			cmd: bson.D{{Key: "find", Value: "orders"}, {Key: "filter", Value: bson.D{{Key: "uid", Value: 7}, {Key: "_id", Value: oid}}},
+				{Key: "sort", Value: bson.D{{Key: "ts", Value: -1}, {Key: "a", Value: 1}}}, {Key: "limit", Value: int64(10)},
+				{Key: "lsid", Value: bson.D{{Key: "id", Value: 1}}}, {Key: "$db", Value: "shop"}},
+			want: `db.orders.find({"uid":7,"_id":{"$oid":"65a1b2c3d4e5f60718293a4b"}}).sort({"ts":-1,"a":1}).limit(10)`,
+		},
+		{
+			cmd:  bson.D{{Key: "find", Value: "order-items"}, {Key: "projection", Value: bson.D{{Key: "a", Value: 1}}}},
+			want: `db.getCollection("order-items").find({}, {"a":1})`,
+		},
+		{
+			cmd: bson.D{{Key: "aggregate", Value: "orders"}, {Key: "pipeline", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{{Key: "ts", Value: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}}}},
+			}}, {Key: "cursor", Value: bson.D{}}, {Key: "allowDiskUse", Value: true}},
+			want: `db.orders.aggregate([{"$match":{"ts":{"$date":"2025-01-02T00:00:00Z"}}}], {"allowDiskUse":true})`,
+		},
+		{
+			cmd:  bson.D{{Key: "insert", Value: "orders"}, {Key: "ordered", Value: false}},
+			seqs: map[string][]bson.Raw{"documents": {raw(bson.D{{Key: "_id", Value: 1}}), raw(bson.D{{Key: "_id", Value: 2}})}},
+			want: `db.orders.insertMany([{"_id":1}, {"_id":2}], {"ordered":false})`,
+		},
+		{
+			cmd:  bson.D{{Key: "insert", Value: "orders"}, {Key: "documents", Value: bson.A{bson.D{{Key: "n", Value: 1.5}}}}},
+			want: `db.orders.insertOne({"n":1.5})`,
+		},
+		{
+			cmd: bson.D{{Key: "update", Value: "orders"}, {Key: "updates", Value: bson.A{
+				bson.D{{Key: "q", Value: bson.D{{Key: "a", Value: 1}}}, {Key: "u", Value: bson.D{{Key: "$set", Value: bson.D{{Key: "b", Value: 2}}}}}, {Key: "multi", Value: true}},
+				bson.D{{Key: "q", Value: bson.D{}}, {Key: "u", Value: bson.D{{Key: "b", Value: 3}}}, {Key: "upsert", Value: true}},
+			}}},
+			want: `db.orders.updateMany({"a":1}, {"$set":{"b":2}}); db.orders.replaceOne({}, {"b":3}, {"upsert":true})`,
+		},
+		{
+			cmd: bson.D{{Key: "delete", Value: "orders"}, {Key: "deletes", Value: bson.A{
+				bson.D{{Key: "q", Value: bson.D{{Key: "a", Value: 1}}}, {Key: "limit", Value: 1}},
+			}}},
+			want: `db.orders.deleteOne({"a":1})`,
+		},
+		{
+			cmd: bson.D{{Key: "findAndModify", Value: "orders"}, {Key: "query", Value: bson.D{{Key: "a", Value: 1}}},
+				{Key: "update", Value: bson.D{{Key: "$inc", Value: bson.D{{Key: "n", Value: 1}}}}}, {Key: "new", Value: true}, {Key: "upsert", Value: true}},
+			want: `db.orders.findOneAndUpdate({"a":1}, {"$inc":{"n":1}}, {"upsert":true,"returnDocument":"after"})`,
+		},
+		{
+			cmd:  bson.D{{Key: "findAndModify", Value: "orders"}, {Key: "query", Value: bson.D{}}, {Key: "sort", Value: bson.D{{Key: "a", Value: 1}}}, {Key: "remove", Value: true}},
+			want: `db.orders.findOneAndDelete({}, {"sort":{"a":1}})`,
+		},
+		{
+			cmd:  bson.D{{Key: "count", Value: "orders"}, {Key: "query", Value: bson.D{{Key: "a", Value: 1}}}},
+			want: `db.orders.count({"a":1})`,
+		},
+		{
+			cmd:  bson.D{{Key: "distinct", Value: "orders"}, {Key: "key", Value: "uid"}, {Key: "query", Value: bson.D{{Key: "a", Value: 1}}}},
+			want: `db.orders.distinct("uid", {"a":1})`,
+		},
+		{
+			cmd: bson.D{{Key: "createIndexes", Value: "orders"}, {Key: "indexes", Value: bson.A{
+				bson.D{{Key: "key", Value: bson.D{{Key: "uid", Value: 1}, {Key: "ts", Value: -1}}}, {Key: "name", Value: "uid_1_ts_-1"}, {Key: "unique", Value: true}},
+			}}},
+			want: `db.orders.createIndex({"uid":1,"ts":-1}, {"name":"uid_1_ts_-1","unique":true})`,
+		},
+		{
+			cmd:  bson.D{{Key: "getMore", Value: int64(42)}, {Key: "collection", Value: "orders"}, {Key: "$db", Value: "shop"}},
+			want: `db.runCommand({"getMore":42,"collection":"orders"})`,
+		},
+		{
+			cmd:  bson.D{{Key: "aggregate", Value: 1}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$currentOp", Value: bson.D{}}}}}},
+			db:   "admin",
+			want: `db.aggregate([{"$currentOp":{}}])`,
+		},
+		{
+			cmd:  bson.D{{Key: "listDatabases", Value: 1}, {Key: "$db", Value: "admin"}},
+			db:   "admin",
+			want: `db.adminCommand({"listDatabases":1})`,
+		},
+	} {
+		if got := renderCommand(c.db, raw(c.cmd), c.seqs); got != c.want {
+			t.Errorf("render %v\ngot:  %s\nwant: %s", c.cmd[0].Key, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+	"github.com/JacksonChan-X/sql-sniffer/tlsdecrypt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩算法，由握手时的能力标志决定
+const (
+	compressNone int32 = iota
+	compressZlib
+	compressZstd
+)
+
+// compression 一条连接两个方向共享的压缩状态。
+// 客户端握手响应确定算法，服务端认证成功的 OK 包之后两个方向都切换为压缩格式
+type compression struct {
+	algorithm atomic.Int32
+	enabled   atomic.Bool
+	server    atomic.Uint32 // 服务端握手包的能力标志
+	greeted   atomic.Bool   // 是否抓到了服务端握手包
+}
+
+// offer 记录服务端握手包的能力标志
+func (c *compression) offer(caps uint32) {
+	c.server.Store(caps)
+	c.greeted.Store(true)
+}
+
+// negotiate 根据客户端握手响应的能力标志确定压缩算法。
+// 服务端不支持时客户端设置的压缩标志无效，没有抓到服务端握手包时只能按客户端的标志判断
+func (c *compression) negotiate(caps uint32) {
+	if c.greeted.Load() {
+		caps &= c.server.Load()
+	}
+	switch {
+	case caps&CLIENT_COMPRESS != 0:
+		c.algorithm.Store(compressZlib)
+	case caps&CLIENT_ZSTD_COMPRESSION_ALGORITHM != 0:
+		c.algorithm.Store(compressZstd)
+	}
+}
+
+// packetReader 读取一个方向的 MySQL 包，SSLRequest 之后先解密 TLS 记录，
+// 开启压缩后先解开压缩包再按普通包解析
+type packetReader struct {
+	src         *helper.PeekStream // 原始流，切换为 TLS 后为解密后的流
+	client      bool
+	compression *compression
+	secure      *secure
+	compressed  bool
+	encrypted   bool
+	plain       []byte // 解压后尚未消费的字节
+	start       int64  // 当前压缩包在 src 中的偏移，用于取抓包时间
+}
+
+func newPacketReader(raw helper.Stream, client bool, c *compression, s *secure) *packetReader {
+	return &packetReader{src: helper.NewPeekStream(raw), client: client, compression: c, secure: s}
+}
+
+// Offset 返回下一个包在 src 中的偏移
+func (pr *packetReader) Offset() int64 {
+	if len(pr.plain) > 0 {
+		return pr.start
+	}
+	return pr.src.Offset()
+}
+
+// Seen 返回 src 中第 offset 个字节的抓包时间
+func (pr *packetReader) Seen(offset int64) time.Time {
+	return pr.src.Seen(offset)
+}
+
+// wait 等到下一个包的数据到达后再决定是否按 TLS、压缩格式读取。
+// 两个方向的数据按抓包顺序交给解析协程，所以此时另一个方向已经处理完 SSLRequest 或认证结果
+func (pr *packetReader) wait() error {
+	if len(pr.plain) > 0 || pr.src.Buffered() > 0 {
+		return nil
+	}
+	if _, err := pr.src.Peek(1); err != nil {
+		return err
+	}
+	if !pr.encrypted && pr.secure.enabled.Load() {
+		pr.encrypted = true
+		pr.src = helper.NewPeekStream(tlsdecrypt.NewReader(pr.src, pr.secure.conn, pr.client))
+	}
+	if !pr.compressed && pr.compression.enabled.Load() {
+		pr.compressed = true
+	}
+	return nil
+}
+
+func (pr *packetReader) Read(p []byte) (int, error) {
+	if pr.compressed {
+		if len(pr.plain) == 0 {
+			if err := pr.inflate(); err != nil {
+				return 0, err
+			}
+		}
+		n := copy(p, pr.plain)
+		pr.plain = pr.plain[n:]
+		return n, nil
+	}
+	return pr.src.Read(p)
+}
+
+// inflate 读取一个压缩包: 压缩后长度(3) + 序号(1) + 压缩前长度(3) + 数据，压缩前长度为 0 表示未压缩
+func (pr *packetReader) inflate() error {
+	pr.start = pr.src.Offset()
+	header := make([]byte, 7)
+	if n, err := io.ReadFull(pr.src, header); err != nil {
+		if n == 0 && err == io.EOF {
+			return io.EOF
+		}
+		return ErrorStream
+	}
+	length := getUint24(header[0:3])
+	plainLength := getUint24(header[4:7])
+	data := make([]byte, length)
+	if _, err := io.ReadFull(pr.src, data); err != nil {
+		return ErrorStream
+	}
+	if plainLength == 0 {
+		pr.plain = data
+		return nil
+	}
+
+	var (
+		plain []byte
+		err   error
+	)
+	switch pr.compression.algorithm.Load() {
+	case compressZstd:
+		plain, err = zstdDecoder.DecodeAll(data, make([]byte, 0, plainLength))
+	default:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(data)); err == nil {
+			plain = make([]byte, plainLength)
+			if _, err = io.ReadFull(zr, plain); err == nil {
+				// 解压后的数据必须正好结束，同时校验 adler32
+				if n, rerr := zr.Read(make([]byte, 1)); n != 0 || rerr != io.EOF {
+					err = ErrCompressedPacket
+				}
+			}
+			zr.Close()
+		}
+	}
+	// 解压后的长度必须与包头中的压缩前长度一致
+	if err != nil || len(plain) != plainLength {
+		return ErrCompressedPacket
+	}
+	pr.plain = plain
+	return nil
+}
+
+// zstdDecoder DecodeAll 可以并发调用，压缩前长度最大为 maxPayloadLength，解压时占用的内存也以此为上限
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxPayloadLength))
@@ -0,0 +1,141 @@
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/google/gopacket/tcpassembly/tcpreader"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedPacket 按压缩协议封装，inner 为一个或多个普通 MySQL 包
+func compressedPacket(seq byte, inner []byte) []byte {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(inner)
+	w.Close()
+	b := []byte{byte(z.Len()), byte(z.Len() >> 8), byte(z.Len() >> 16), seq,
+		byte(len(inner)), byte(len(inner) >> 8), byte(len(inner) >> 16)}
+	return append(b, z.Bytes()...)
+}
+
+func plainPacket(seq byte, payload []byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+}
+
+func TestPacketReaderCompressed(t *testing.T) {
+	query := plainPacket(0, []byte("\x03SELECT 1"))
+	data := plainPacket(2, []byte{iOK, 0, 0, 2, 0, 0, 0})
+	// 一个 MySQL 包被拆到两个压缩包中
+	data = append(data, compressedPacket(0, query[:5])...)
+	data = append(data, compressedPacket(1, query[5:])...)
+
+	s := helper.NewTimedStream()
+	go func() {
+		s.Reassembled([]tcpassembly.Reassembly{{Bytes: data}})
+		s.ReassemblyComplete()
+	}()
+
+	c := &compression{}
+	c.negotiate(CLIENT_COMPRESS)
+	pr := newPacketReader(s, true, c, newSecure())
+	m := &Mysql{}
+	for i, want := range [][]byte{{iOK, 0, 0, 2, 0, 0, 0}, []byte("\x03SELECT 1")} {
+		if err := pr.wait(); err != nil {
+			t.Fatal(err)
+		}
+		_, payload, _, err := m.resolvePacket(pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload.Bytes(), want) {
+			t.Fatalf("packet %d: got %q, want %q", i, payload.Bytes(), want)
+		}
+		// 认证成功的 OK 包之后开启压缩
+		c.enabled.Store(true)
+	}
+}
+
+func TestInflateLength(t *testing.T) {
+	inner := []byte("\x03SELECT 1")
+	enc, _ := zstd.NewWriter(nil)
+	zstdData := enc.EncodeAll(inner, nil)
+	// withPlainLength 把压缩包头中的压缩前长度改为 n
+	withPlainLength := func(packet []byte, n int) []byte {
+		packet = append([]byte(nil), packet...)
+		packet[4], packet[5], packet[6] = byte(n), byte(n>>8), byte(n>>16)
+		return packet
+	}
+	zstdPacket := append([]byte{byte(len(zstdData)), 0, 0, 0, 0, 0, 0}, zstdData...)
+	for i, c := range []struct {
+		algorithm uint32
+		packet    []byte
+	}{
+		{CLIENT_COMPRESS, withPlainLength(compressedPacket(0, inner), len(inner)-1)},
+		{CLIENT_COMPRESS, withPlainLength(compressedPacket(0, inner), len(inner)+1)},
+		{CLIENT_ZSTD_COMPRESSION_ALGORITHM, withPlainLength(zstdPacket, len(inner)-1)},
+		{CLIENT_ZSTD_COMPRESSION_ALGORITHM, withPlainLength(zstdPacket, len(inner)+1)},
+	} {
+		s := helper.NewTimedStream()
+		go func() {
+			s.Reassembled([]tcpassembly.Reassembly{{Bytes: c.packet}})
+			s.ReassemblyComplete()
+		}()
+		comp := &compression{}
+		comp.negotiate(c.algorithm)
+		pr := newPacketReader(s, true, comp, newSecure())
+		pr.compressed = true
+		if err := pr.inflate(); err != ErrCompressedPacket {
+			t.Errorf("case %d: err = %v, want %v", i, err, ErrCompressedPacket)
+		}
+		tcpreader.DiscardBytesToEOF(s)
+	}
+}
+
+// greeting 服务端握手包 (HandshakeV10)
+func greeting(caps uint32) []byte {
+	data := append([]byte{10}, "8.0.36\x00"...)
+	data = binary.LittleEndian.AppendUint32(data, 7)
+	data = append(data, make([]byte, 9)...) // auth-plugin-data-part-1 + filler
+	data = binary.LittleEndian.AppendUint16(data, uint16(caps))
+	data = append(data, 45, 2, 0)
+	data = binary.LittleEndian.AppendUint16(data, uint16(caps>>16))
+	data = append(data, 21)
+	data = append(data, make([]byte, 10+13)...)
+	return append(data, "caching_sha2_password\x00"...)
+}
+
+func TestNegotiateServerCaps(t *testing.T) {
+	base := uint32(CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH)
+	for i, c := range []struct {
+		server uint32 // 0 表示没有抓到服务端握手包
+		client uint32
+		want   int32
+	}{
+		{base, base | CLIENT_COMPRESS, compressNone},
+		{base, base | CLIENT_ZSTD_COMPRESSION_ALGORITHM, compressNone},
+		{base | CLIENT_COMPRESS, base | CLIENT_COMPRESS, compressZlib},
+		{base | CLIENT_ZSTD_COMPRESSION_ALGORITHM, base | CLIENT_COMPRESS | CLIENT_ZSTD_COMPRESSION_ALGORITHM, compressZstd},
+		{0, base | CLIENT_COMPRESS, compressZlib},
+	} {
+		m := &Mysql{}
+		comp := &compression{}
+		if c.server != 0 {
+			m.detectGreeting(comp, &Packet{Payload: greeting(c.server)})
+		}
+		login := binary.LittleEndian.AppendUint32(nil, c.client)
+		login = binary.LittleEndian.AppendUint32(login, 1<<24)
+		login = append(login, 45)
+		login = append(login, make([]byte, 23)...)
+		login = append(login, "app\x00\x00caching_sha2_password\x00"...)
+		m.detectLogin(comp, newSecure(), &Packet{IsClientFlow: true, Seq: 1, Payload: login})
+		if got := comp.algorithm.Load(); got != c.want {
+			t.Errorf("case %d: algorithm = %d, want %d", i, got, c.want)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package mysql
 
+import "fmt"
+
 const (
 	PARAM_UNSIGNED = 128
 )
@@ -72,3 +74,143 @@ const (
 	MYSQL_TYPE_STRING
 	MYSQL_TYPE_GEOMETRY
 )
+
+// 服务端响应包的首字节
+const (
+	iOK          byte = 0x00
+	iLocalInFile byte = 0xfb
+	iEOF         byte = 0xfe
+	iERR         byte = 0xff
+)
+
+// 服务端状态标志，出现在 OK 和 EOF 包中
+const (
+	SERVER_STATUS_IN_TRANS             uint16 = 0x0001
+	SERVER_STATUS_AUTOCOMMIT           uint16 = 0x0002
+	SERVER_MORE_RESULTS_EXISTS         uint16 = 0x0008
+	SERVER_STATUS_NO_GOOD_INDEX_USED   uint16 = 0x0010
+	SERVER_STATUS_NO_INDEX_USED        uint16 = 0x0020
+	SERVER_STATUS_CURSOR_EXISTS        uint16 = 0x0040
+	SERVER_STATUS_LAST_ROW_SENT        uint16 = 0x0080
+	SERVER_STATUS_DB_DROPPED           uint16 = 0x0100
+	SERVER_STATUS_NO_BACKSLASH_ESCAPES uint16 = 0x0200
+	SERVER_STATUS_METADATA_CHANGED     uint16 = 0x0400
+	SERVER_QUERY_WAS_SLOW              uint16 = 0x0800
+	SERVER_PS_OUT_PARAMS               uint16 = 0x1000
+	SERVER_STATUS_IN_TRANS_READONLY    uint16 = 0x2000
+	SERVER_SESSION_STATE_CHANGED       uint16 = 0x4000
+)
+
+// 客户端/服务端能力标志，出现在握手包中
+const (
+	CLIENT_LONG_PASSWORD                  uint32 = 0x00000001
+	CLIENT_FOUND_ROWS                     uint32 = 0x00000002
+	CLIENT_LONG_FLAG                      uint32 = 0x00000004
+	CLIENT_CONNECT_WITH_DB                uint32 = 0x00000008
+	CLIENT_NO_SCHEMA                      uint32 = 0x00000010
+	CLIENT_COMPRESS                       uint32 = 0x00000020
+	CLIENT_ODBC                           uint32 = 0x00000040
+	CLIENT_LOCAL_FILES                    uint32 = 0x00000080
+	CLIENT_IGNORE_SPACE                   uint32 = 0x00000100
+	CLIENT_PROTOCOL_41                    uint32 = 0x00000200
+	CLIENT_INTERACTIVE                    uint32 = 0x00000400
+	CLIENT_SSL                            uint32 = 0x00000800
+	CLIENT_IGNORE_SIGPIPE                 uint32 = 0x00001000
+	CLIENT_TRANSACTIONS                   uint32 = 0x00002000
+	CLIENT_RESERVED                       uint32 = 0x00004000
+	CLIENT_SECURE_CONNECTION              uint32 = 0x00008000
+	CLIENT_MULTI_STATEMENTS               uint32 = 0x00010000
+	CLIENT_MULTI_RESULTS                  uint32 = 0x00020000
+	CLIENT_PS_MULTI_RESULTS               uint32 = 0x00040000
+	CLIENT_PLUGIN_AUTH                    uint32 = 0x00080000
+	CLIENT_CONNECT_ATTRS                  uint32 = 0x00100000
+	CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA uint32 = 0x00200000
+	CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS   uint32 = 0x00400000
+	CLIENT_SESSION_TRACK                  uint32 = 0x00800000
+	CLIENT_DEPRECATE_EOF                  uint32 = 0x01000000
+	CLIENT_OPTIONAL_RESULTSET_METADATA    uint32 = 0x02000000
+	CLIENT_ZSTD_COMPRESSION_ALGORITHM     uint32 = 0x04000000
+	CLIENT_QUERY_ATTRIBUTES               uint32 = 0x08000000
+)
+
+var typeNames = map[byte]string{
+	MYSQL_TYPE_DECIMAL:     "DECIMAL",
+	MYSQL_TYPE_TINY:        "TINY",
+	MYSQL_TYPE_SHORT:       "SHORT",
+	MYSQL_TYPE_LONG:        "LONG",
+	MYSQL_TYPE_FLOAT:       "FLOAT",
+	MYSQL_TYPE_DOUBLE:      "DOUBLE",
+	MYSQL_TYPE_NULL:        "NULL",
+	MYSQL_TYPE_TIMESTAMP:   "TIMESTAMP",
+	MYSQL_TYPE_LONGLONG:    "LONGLONG",
+	MYSQL_TYPE_INT24:       "INT24",
+	MYSQL_TYPE_DATE:        "DATE",
+	MYSQL_TYPE_TIME:        "TIME",
+	MYSQL_TYPE_DATETIME:    "DATETIME",
+	MYSQL_TYPE_YEAR:        "YEAR",
+	MYSQL_TYPE_NEWDATE:     "NEWDATE",
+	MYSQL_TYPE_VARCHAR:     "VARCHAR",
+	MYSQL_TYPE_BIT:         "BIT",
+	MYSQL_TYPE_JSON:        "JSON",
+	MYSQL_TYPE_NEWDECIMAL:  "NEWDECIMAL",
+	MYSQL_TYPE_ENUM:        "ENUM",
+	MYSQL_TYPE_SET:         "SET",
+	MYSQL_TYPE_TINY_BLOB:   "TINY_BLOB",
+	MYSQL_TYPE_MEDIUM_BLOB: "MEDIUM_BLOB",
+	MYSQL_TYPE_LONG_BLOB:   "LONG_BLOB",
+	MYSQL_TYPE_BLOB:        "BLOB",
+	MYSQL_TYPE_VAR_STRING:  "VAR_STRING",
+	MYSQL_TYPE_STRING:      "STRING",
+	MYSQL_TYPE_GEOMETRY:    "GEOMETRY",
+}
+
+// TypeName 返回字段类型的名称
+func TypeName(tp byte) string {
+	if name, ok := typeNames[tp]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", tp)
+}
+
+var commandNames = map[byte]string{
+	COM_SLEEP:               "COM_SLEEP",
+	COM_QUIT:                "COM_QUIT",
+	COM_INIT_DB:             "COM_INIT_DB",
+	COM_QUERY:               "COM_QUERY",
+	COM_FIELD_LIST:          "COM_FIELD_LIST",
+	COM_CREATE_DB:           "COM_CREATE_DB",
+	COM_DROP_DB:             "COM_DROP_DB",
+	COM_REFRESH:             "COM_REFRESH",
+	COM_SHUTDOWN:            "COM_SHUTDOWN",
+	COM_STATISTICS:          "COM_STATISTICS",
+	COM_PROCESS_INFO:        "COM_PROCESS_INFO",
+	COM_CONNECT:             "COM_CONNECT",
+	COM_PROCESS_KILL:        "COM_PROCESS_KILL",
+	COM_DEBUG:               "COM_DEBUG",
+	COM_PING:                "COM_PING",
+	COM_TIME:                "COM_TIME",
+	COM_DELAYED_INSERT:      "COM_DELAYED_INSERT",
+	COM_CHANGE_USER:         "COM_CHANGE_USER",
+	COM_BINLOG_DUMP:         "COM_BINLOG_DUMP",
+	COM_TABLE_DUMP:          "COM_TABLE_DUMP",
+	COM_CONNECT_OUT:         "COM_CONNECT_OUT",
+	COM_REGISTER_SLAVE:      "COM_REGISTER_SLAVE",
+	COM_STMT_PREPARE:        "COM_STMT_PREPARE",
+	COM_STMT_EXECUTE:        "COM_STMT_EXECUTE",
+	COM_STMT_SEND_LONG_DATA: "COM_STMT_SEND_LONG_DATA",
+	COM_STMT_CLOSE:          "COM_STMT_CLOSE",
+	COM_STMT_RESET:          "COM_STMT_RESET",
+	COM_SET_OPTION:          "COM_SET_OPTION",
+	COM_STMT_FETCH:          "COM_STMT_FETCH",
+	COM_DAEMON:              "COM_DAEMON",
+	COM_BINLOG_DUMP_GTID:    "COM_BINLOG_DUMP_GTID",
+	COM_RESET_CONNECTION:    "COM_RESET_CONNECTION",
+}
+
+// CommandName 返回命令字节对应的名称
+func CommandName(cmd byte) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("COM_UNKNOWN(%d)", cmd)
+}
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/decoder"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	decoder.Register(Decoder{})
+}
+
+var (
+	slow        time.Duration // 慢查询阈值，由 --slow 设置
+	longTx      time.Duration // 长事务告警阈值，由 --long_tx 设置
+	idleTx      time.Duration // 事务空闲告警阈值，由 --idle_tx 设置
+	maxLongData int           // 每个参数保留的 long data 字节数，由 --max_long_data 设置
+	maxStmtSize int           // 每个包保留的负载字节数，由 --max_statement_size 设置
+	captureRows int           // 每条语句保留的结果集行数，由 --capture_rows 设置
+	maxRowBytes int           // 每行保留的字节数，由 --max_row_bytes 设置
+	errSummary  time.Duration // 错误汇总的周期，由 --error_summary 设置
+)
+
+// Decoder 注册到 decoder 包的 MySQL 解析器
+type Decoder struct{}
+
+func (Decoder) Name() string {
+	return event.ProtocolMySQL
+}
+
+func (Decoder) DefaultPorts() []string {
+	return []string{"3306"}
+}
+
+func (Decoder) Flags(fs *pflag.FlagSet) {
+	fs.DurationVar(&slow, "slow", 0, "只输出耗时超过该值的 MySQL 语句，如 200ms，0 表示全部输出")
+	fs.DurationVar(&longTx, "long_tx", 0, "MySQL 事务持续超过该值时告警，如 10s，0 表示不告警")
+	fs.DurationVar(&idleTx, "idle_tx", 0, "MySQL 事务中两条语句间隔超过该值时告警，如 2s，0 表示不告警")
+	fs.DurationVar(&errSummary, "error_summary", time.Minute, "按该周期输出 MySQL 错误汇总，按错误分类、语句指纹和客户端统计，0 表示不输出")
+	fs.IntVar(&maxStmtSize, "max_statement_size", 64<<20, "每条语句(包括超过16MB被拆分的包)最多保留的字节数，超出部分标记为截断，0 表示不限制")
+	fs.IntVar(&captureRows, "capture_rows", 0, "每条 MySQL 语句输出结果集的前 N 行，0 表示不输出")
+	fs.IntVar(&maxRowBytes, "max_row_bytes", 4096, "输出的每行中文本和二进制值最多保留的字节数，超出部分标记为截断，0 表示不限制")
+	fs.IntVar(&maxLongData, "max_long_data", 1<<20, "COM_STMT_SEND_LONG_DATA 每个参数最多保留的字节数，超出部分标记为截断，0 表示不限制")
+}
+
+func (Decoder) NewFactory(opts decoder.Options) decoder.StreamFactory {
+	f := NewMysqlStreamFactory(opts.Port, opts.Logger, opts.Sink)
+	f.mysql.clock.replay = opts.Replay
+	f.mysql.slow = slow
+	f.mysql.longTx = longTx
+	f.mysql.idleTx = idleTx
+	f.mysql.maxLongData = maxLongData
+	f.mysql.maxStatementSize = maxStmtSize
+	f.mysql.captureRows = captureRows
+	f.mysql.maxRowBytes = maxRowBytes
+	if errSummary > 0 {
+		f.mysql.errors = acquireErrorStats(errSummary, opts.Sink, !opts.Replay)
+	}
+	return f
+}
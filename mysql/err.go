@@ -3,7 +3,8 @@ package mysql
 import "errors"
 
 var (
-	ErrMalformPacket = errors.New("MALFORM_PACKET")
-	ErrorStream      = errors.New("STREAM INVALID")
-	ErrTimeOut       = errors.New("stream timeout")
+	ErrMalformPacket    = errors.New("MALFORM_PACKET")
+	ErrorStream         = errors.New("STREAM INVALID")
+	ErrTimeOut          = errors.New("stream timeout")
+	ErrCompressedPacket = errors.New("COMPRESSED PACKET INVALID")
 )
@@ -0,0 +1,380 @@
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+)
+
+// 错误分类
+const (
+	errDeadlock           = "deadlock"
+	errLockWaitTimeout    = "lock_wait_timeout"
+	errDuplicateKey       = "duplicate_key"
+	errAccessDenied       = "access_denied"
+	errSyntax             = "syntax"
+	errTooManyConnections = "too_many_connections"
+	errUnknownObject      = "unknown_object" // 库、表、列不存在
+	errConstraint         = "constraint"     // 外键、非空等约束
+	errData               = "data"           // 数据超出范围、截断、格式错误
+	errTimeout            = "timeout"        // 执行超时或被 KILL
+	errReadOnly           = "read_only"
+	errResource           = "resource" // 磁盘、内存、表空间不足
+	errConnection         = "connection"
+	errOther              = "other"
+)
+
+// errorClasses 错误码到分类的映射，未列出的错误码按 SQLSTATE 分类
+var errorClasses = map[uint16]string{
+	1213: errDeadlock,
+	1205: errLockWaitTimeout,
+	1022: errDuplicateKey, 1062: errDuplicateKey, 1169: errDuplicateKey, 1557: errDuplicateKey, 1586: errDuplicateKey,
+	1044: errAccessDenied, 1045: errAccessDenied, 1142: errAccessDenied, 1143: errAccessDenied,
+	1227: errAccessDenied, 1251: errAccessDenied, 1370: errAccessDenied, 1698: errAccessDenied, 1862: errAccessDenied,
+	1064: errSyntax, 1065: errSyntax, 1149: errSyntax,
+	1040: errTooManyConnections, 1203: errTooManyConnections, 1226: errTooManyConnections,
+	1049: errUnknownObject, 1051: errUnknownObject, 1054: errUnknownObject, 1091: errUnknownObject,
+	1146: errUnknownObject, 1305: errUnknownObject,
+	1048: errConstraint, 1216: errConstraint, 1217: errConstraint, 1451: errConstraint, 1452: errConstraint, 3819: errConstraint,
+	1264: errData, 1265: errData, 1292: errData, 1366: errData, 1406: errData,
+	1317: errTimeout, 1969: errTimeout, 3024: errTimeout,
+	1290: errReadOnly, 1792: errReadOnly, 1836: errReadOnly,
+	1021: errResource, 1037: errResource, 1038: errResource, 1114: errResource, 1135: errResource,
+	1053: errConnection, 1152: errConnection, 1153: errConnection, 1158: errConnection, 1159: errConnection,
+	1160: errConnection, 1161: errConnection,
+}
+
+// ErrorClass 按错误码和 SQLSTATE 对错误分类
+func ErrorClass(code uint16, sqlState string) string {
+	if class, ok := errorClasses[code]; ok {
+		return class
+	}
+	switch {
+	case strings.HasPrefix(sqlState, "40"):
+		return errDeadlock
+	case strings.HasPrefix(sqlState, "23"):
+		return errConstraint
+	case strings.HasPrefix(sqlState, "28"):
+		return errAccessDenied
+	case strings.HasPrefix(sqlState, "08"):
+		return errConnection
+	case strings.HasPrefix(sqlState, "22"):
+		return errData
+	case strings.HasPrefix(sqlState, "42S"):
+		return errUnknownObject
+	case strings.HasPrefix(sqlState, "42"):
+		return errSyntax
+	}
+	return errOther
+}
+
+const (
+	maxSummaryKeys    = 10000 // 每个周期最多统计的指纹和客户端数
+	maxSummaryEntries = 20    // 汇总中输出的指纹和客户端数
+)
+
+// errorStats 统计一个周期内的错误，周期从第一个错误的抓包时间开始。
+// 回放时只按抓包时间划分周期，实时抓包时抓包时间即本地时间，没有新错误也按本地时间输出
+type errorStats struct {
+	interval time.Duration
+	sink     event.Sink
+	live     bool
+	mutex    sync.Mutex
+	start    time.Time // 当前周期第一个错误的抓包时间
+	total    int
+	classes  map[string]int
+	fps      map[string]*event.ErrorCount
+	clients  map[string]*event.ErrorCount
+	refs     int // 共用该统计的 factory 数
+	once     sync.Once
+	stop     chan struct{}
+}
+
+func newErrorStats(interval time.Duration, sink event.Sink, live bool) *errorStats {
+	return &errorStats{interval: interval, sink: sink, live: live, stop: make(chan struct{})}
+}
+
+// sharedErrors 实时抓包时每个网卡和端口各有一个 factory，共用一个错误统计，同一周期只输出一条汇总
+var sharedErrors struct {
+	sync.Mutex
+	stats *errorStats
+}
+
+// acquireErrorStats 返回共用的错误统计，factory 结束时调用 release
+func acquireErrorStats(interval time.Duration, sink event.Sink, live bool) *errorStats {
+	sharedErrors.Lock()
+	defer sharedErrors.Unlock()
+	if sharedErrors.stats == nil {
+		sharedErrors.stats = newErrorStats(interval, sink, live)
+	}
+	sharedErrors.stats.refs++
+	return sharedErrors.stats
+}
+
+// release 最后一个 factory 结束时输出最后一个周期的统计
+func (s *errorStats) release() {
+	sharedErrors.Lock()
+	defer sharedErrors.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	if sharedErrors.stats == s {
+		sharedErrors.stats = nil
+	}
+	s.Close()
+}
+
+// record 统计一个出错的语句
+func (s *errorStats) record(e *event.Event) {
+	if s.interval <= 0 {
+		return
+	}
+	if s.live {
+		s.once.Do(func() { go s.run() })
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.total > 0 && e.Timestamp.Sub(s.start) >= s.interval {
+		s.flush()
+	}
+	if s.total == 0 {
+		s.start = e.Timestamp
+		s.classes = make(map[string]int)
+		s.fps = make(map[string]*event.ErrorCount)
+		s.clients = make(map[string]*event.ErrorCount)
+	}
+	s.total++
+	s.classes[e.ErrorClass]++
+	countError(s.fps, Fingerprint(e.Statement), e)
+	client, _, err := net.SplitHostPort(e.ClientAddr)
+	if err != nil {
+		client = e.ClientAddr
+	}
+	countError(s.clients, client, e)
+}
+
+func countError(counts map[string]*event.ErrorCount, key string, e *event.Event) {
+	c, ok := counts[key]
+	if !ok {
+		if len(counts) >= maxSummaryKeys {
+			return
+		}
+		c = &event.ErrorCount{Key: key, Classes: make(map[string]int)}
+		counts[key] = c
+	}
+	c.Count++
+	c.Classes[e.ErrorClass]++
+	c.Sample = e.Text()
+}
+
+// run 实时抓包时错误停止后，按本地时间输出最后一个周期，此时抓包时间与本地时间一致
+func (s *errorStats) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			if s.total > 0 && now.Sub(s.start) >= s.interval {
+				s.flush()
+			}
+			s.mutex.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close 输出最后一个周期的统计
+func (s *errorStats) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.total > 0 {
+		s.flush()
+	}
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
+
+// flush 输出当前周期的统计并清空，调用时需持有锁
+func (s *errorStats) flush() {
+	summary := &event.ErrorSummary{
+		Start:        s.start,
+		Duration:     s.interval,
+		Total:        s.total,
+		Classes:      s.classes,
+		Fingerprints: topErrors(s.fps),
+		Clients:      topErrors(s.clients),
+	}
+	s.total = 0
+	s.classes, s.fps, s.clients = nil, nil, nil
+
+	classes := make([]string, 0, len(summary.Classes))
+	for class, n := range summary.Classes {
+		classes = append(classes, fmt.Sprintf("%s:%d", class, n))
+	}
+	sort.Strings(classes)
+	s.sink.Emit(&event.Event{
+		Protocol:     event.ProtocolMySQL,
+		Command:      "ERROR_SUMMARY",
+		Statement:    fmt.Sprintf("Errors:%d in %s, %s", summary.Total, s.interval, strings.Join(classes, ",")),
+		Timestamp:    summary.Start,
+		Result:       event.ResultError,
+		ErrorSummary: summary,
+	})
+}
+
+// topErrors 按错误数从多到少排序，只保留前 maxSummaryEntries 个
+func topErrors(counts map[string]*event.ErrorCount) []event.ErrorCount {
+	out := make([]event.ErrorCount, 0, len(counts))
+	for _, c := range counts {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	if len(out) > maxSummaryEntries {
+		out = out[:maxSummaryEntries]
+	}
+	return out
+}
+
+const (
+	maxConflictStatements = 20               // 并发事务中最多保留的最近语句数
+	activityRetention     = 10 * time.Second // 结束的事务和语句保留的抓包时间，两个方向的协程处理进度不同
+)
+
+// activity 一个连接最近的事务和语句，用于死锁时找出并发的事务
+type activity struct {
+	server     string
+	client     string
+	user       string
+	txStart    time.Time // 事务开始的抓包时间，不在事务中时为空
+	txEnd      time.Time // 事务结束的抓包时间，事务进行中时为空
+	statements []string  // 事务中最近的语句
+	last       string    // 最近一条语句
+	lastStart  time.Time
+	lastEnd    time.Time // 最近一条语句收到响应的抓包时间，等待响应时为空
+}
+
+// activities 同一端口上所有连接的活动，各连接的协程共用
+type activities struct {
+	mutex sync.Mutex
+	conns map[string]*activity
+}
+
+func newActivities() *activities {
+	return &activities{conns: make(map[string]*activity)}
+}
+
+// publish 记录连接当前的事务和正在等待响应的语句，ts 为当前的抓包时间
+func (stm *Stream) publish(ts time.Time) {
+	if stm.activities == nil {
+		return
+	}
+	a := &activity{server: stm.serverAddr, client: stm.clientAddr, user: stm.user}
+	if tx := stm.tx; tx != nil {
+		a.txStart = tx.start
+		a.statements = append([]string(nil), tx.statements[max(len(tx.statements)-maxConflictStatements, 0):]...)
+	}
+
+	acts := stm.activities
+	acts.mutex.Lock()
+	defer acts.mutex.Unlock()
+	if old, ok := acts.conns[stm.ID]; ok {
+		// 保留刚结束的事务和语句，死锁时对方可能已经处理到提交之后
+		if a.txStart.IsZero() && !old.txStart.IsZero() {
+			a.txStart, a.txEnd, a.statements = old.txStart, old.txEnd, old.statements
+			if a.txEnd.IsZero() {
+				a.txEnd = ts
+			}
+		}
+		a.last, a.lastStart, a.lastEnd = old.last, old.lastStart, old.lastEnd
+	}
+	if c := stm.pending; c != nil && c.cmd != COM_CONNECT {
+		a.last, a.lastStart, a.lastEnd = c.event.Text(), c.event.Timestamp, time.Time{}
+	} else if a.last != "" && a.lastEnd.IsZero() {
+		a.lastEnd = ts
+	}
+	acts.conns[stm.ID] = a
+}
+
+// unpublish 连接关闭
+func (stm *Stream) unpublish() {
+	if stm.activities == nil {
+		return
+	}
+	stm.activities.mutex.Lock()
+	delete(stm.activities.conns, stm.ID)
+	stm.activities.mutex.Unlock()
+}
+
+// concurrent 返回 ts 时同一服务端上其他连接正在进行的事务和语句
+func (stm *Stream) concurrent(ts time.Time) []event.ConcurrentTransaction {
+	if stm.activities == nil {
+		return nil
+	}
+	acts := stm.activities
+	acts.mutex.Lock()
+	defer acts.mutex.Unlock()
+
+	var out []event.ConcurrentTransaction
+	for id, a := range acts.conns {
+		if id == stm.ID || a.server != stm.serverAddr {
+			continue
+		}
+		inTx := !a.txStart.IsZero() && !a.txStart.After(ts) && (a.txEnd.IsZero() || !a.txEnd.Before(ts))
+		waiting := a.last != "" && !a.lastStart.After(ts) && (a.lastEnd.IsZero() || !a.lastEnd.Before(ts))
+		if !inTx && !waiting {
+			if !a.txEnd.IsZero() && ts.Sub(a.txEnd) > activityRetention {
+				a.txStart, a.txEnd, a.statements = time.Time{}, time.Time{}, nil
+			}
+			continue
+		}
+		t := event.ConcurrentTransaction{ConnID: id, ClientAddr: a.client, User: a.user}
+		if inTx {
+			t.Statements = a.statements
+		}
+		if waiting {
+			t.Waiting = a.last
+		}
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnID < out[j].ConnID })
+	return out
+}
+
+// recordError 对出错的语句分类并统计，死锁和锁等待超时时带上并发的事务
+func (stm *Stream) recordError(e *event.Event) {
+	e.ErrorClass = ErrorClass(uint16(e.ErrorCode), e.SQLState)
+	if e.ErrorClass == errDeadlock || e.ErrorClass == errLockWaitTimeout {
+		var statements []string
+		if stm.tx != nil {
+			statements = append(statements, stm.tx.statements...)
+		}
+		e.LockConflict = &event.LockConflict{
+			Statements: append(statements, e.Text()),
+			Concurrent: stm.concurrent(e.Timestamp),
+		}
+		e.Alert = event.AlertDeadlock
+		if e.ErrorClass == errLockWaitTimeout {
+			e.Alert = event.AlertLockWaitTimeout
+		}
+	}
+	if stm.errors != nil {
+		stm.errors.record(e)
+	}
+}
@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFingerprint(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM `User` WHERE id = 10 AND name='jack''son' -- comment": "select * from `User` where id = ? and name=?",
+		"select  *\n from user where id in (1, 2,3) /* hint */ ;":            "select * from user where id in (?+)",
+		"INSERT INTO t1 (a, b) VALUES (1, \"x\"), (2, 'y')":                  "insert into t1 (a, b) values (?+)",
+		"UPDATE t SET v = v + 1.5e-3 WHERE k2 = 0x1F":                        "update t set v = v + ? where k2 = ?",
+	}
+	for sql, want := range cases {
+		if got := Fingerprint(sql); got != want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
+
+func errPacket(code uint16, state, msg string) []byte {
+	b := []byte{iERR, 0, 0, '#'}
+	binary.LittleEndian.PutUint16(b[1:3], code)
+	return append(append(b, state...), msg...)
+}
+
+func okPacket(status uint16) []byte {
+	b := []byte{iOK, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(b[3:5], status)
+	return b
+}
+
+func TestDeadlock(t *testing.T) {
+	var sink sliceSink
+	acts := newActivities()
+	stats := newErrorStats(time.Minute, &sink, false)
+	newStream := func(id, client string) *Stream {
+		return &Stream{ID: id, logger: logrus.New(), sink: &sink, clientAddr: client, serverAddr: "10.0.0.1:3306",
+			StmtMap: map[uint32]*Statement{}, activities: acts, errors: stats}
+	}
+	a, b := newStream("a", "10.0.0.2:5000"), newStream("b", "10.0.0.3:5000")
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	exec := func(stm *Stream, sql string, at time.Duration, resp []byte) {
+		query := append([]byte{COM_QUERY}, sql...)
+		stm.resolveClientPacket(&Packet{IsClientFlow: true, Payload: query, Length: len(query), Timestamp: t0.Add(at)})
+		if resp != nil {
+			stm.resolveServerPacket(&Packet{Seq: 1, Payload: resp, Length: len(resp), Timestamp: t0.Add(at + time.Millisecond)})
+		}
+	}
+
+	exec(a, "BEGIN", 0, okPacket(SERVER_STATUS_IN_TRANS))
+	exec(b, "BEGIN", 0, okPacket(SERVER_STATUS_IN_TRANS))
+	exec(a, "UPDATE t SET v = 1 WHERE id = 1", time.Second, okPacket(SERVER_STATUS_IN_TRANS))
+	exec(b, "UPDATE t SET v = 2 WHERE id = 2", time.Second, okPacket(SERVER_STATUS_IN_TRANS))
+	exec(a, "UPDATE t SET v = 1 WHERE id = 2", 2*time.Second, nil) // 等待 b 的锁
+	exec(b, "UPDATE t SET v = 2 WHERE id = 1", 3*time.Second, errPacket(1213, "40001", "Deadlock found"))
+
+	var deadlock *event.Event
+	for _, e := range sink {
+		if e.Alert == event.AlertDeadlock {
+			deadlock = e
+		}
+	}
+	if deadlock == nil || deadlock.ErrorClass != errDeadlock || deadlock.LockConflict == nil {
+		t.Fatalf("deadlock not found: %+v", sink)
+	}
+	conflict := deadlock.LockConflict
+	if len(conflict.Statements) != 3 || conflict.Statements[2] != "UPDATE t SET v = 2 WHERE id = 1" {
+		t.Fatalf("unexpected statements: %q", conflict.Statements)
+	}
+	if len(conflict.Concurrent) != 1 || conflict.Concurrent[0].ConnID != "a" ||
+		conflict.Concurrent[0].Waiting != "UPDATE t SET v = 1 WHERE id = 2" || len(conflict.Concurrent[0].Statements) != 2 {
+		t.Fatalf("unexpected concurrent: %+v", conflict.Concurrent)
+	}
+	if b.tx != nil {
+		t.Fatal("deadlock should roll back the transaction")
+	}
+
+	exec(b, "INSERT INTO t VALUES (1)", 4*time.Second, errPacket(1062, "23000", "Duplicate entry"))
+	exec(b, "INSERT INTO t VALUES (2)", 5*time.Second, errPacket(1062, "23000", "Duplicate entry"))
+	stats.Close()
+	summary := sink[len(sink)-1].ErrorSummary
+	if summary == nil || summary.Total != 3 || summary.Classes[errDuplicateKey] != 2 ||
+		summary.Fingerprints[0].Key != "insert into t values (?+)" || summary.Fingerprints[0].Count != 2 ||
+		len(summary.Clients) != 1 || summary.Clients[0].Key != "10.0.0.3" {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestErrorStatsShared(t *testing.T) {
+	var sink sliceSink
+	// 两个 factory 共用一个统计，回放时按抓包时间划分周期
+	a := acquireErrorStats(time.Minute, &sink, false)
+	b := acquireErrorStats(time.Minute, &sink, false)
+	if a != b {
+		t.Fatal("error stats not shared")
+	}
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, at := range []time.Duration{0, 30 * time.Second, 50 * time.Second, 70 * time.Second} {
+		stats := []*errorStats{a, b}[i%2]
+		stats.record(&event.Event{ClientAddr: "10.0.0.2:5000", Statement: "SELECT 1", Timestamp: t0.Add(at), ErrorClass: errSyntax})
+	}
+	if len(sink) != 1 || sink[0].ErrorSummary.Total != 3 || !sink[0].Timestamp.Equal(t0) {
+		t.Fatalf("unexpected summaries: %+v", sink)
+	}
+	a.release()
+	if len(sink) != 1 {
+		t.Fatalf("summary flushed before the last factory: %+v", sink)
+	}
+	b.release()
+	if len(sink) != 2 || sink[1].ErrorSummary.Total != 1 {
+		t.Fatalf("unexpected summaries: %+v", sink)
+	}
+	c := acquireErrorStats(time.Minute, &sink, false)
+	defer c.release()
+	if c == a {
+		t.Fatal("released error stats reused")
+	}
+}
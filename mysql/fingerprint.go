@@ -0,0 +1,115 @@
+package mysql
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	valueList  = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	valueLists = regexp.MustCompile(`\(\?\+\)(?:\s*,\s*\(\?\+\))+`)
+)
+
+// Fingerprint 归一化语句，相同结构、不同参数的语句得到相同的指纹:
+// 去掉注释，字符串和数字替换为 ?，IN 和 VALUES 的值列表合并为 (?+)，关键字和标识符转为小写
+func Fingerprint(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+	space := false
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(sql, i)
+			writeToken(&b, &space, "?")
+			continue
+		case c == '`':
+			end := strings.IndexByte(sql[i+1:], '`')
+			if end < 0 {
+				end = len(sql) - i - 1
+			} else {
+				end += 2
+			}
+			writeToken(&b, &space, sql[i:i+end])
+			i += end
+			continue
+		case c == '#' || c == '-' && strings.HasPrefix(sql[i:], "-- "):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end
+			space = b.Len() > 0
+			continue
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i
+			} else {
+				end += 4
+			}
+			i += end
+			space = b.Len() > 0
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = b.Len() > 0
+			i++
+			continue
+		case isDigit(c) && (i == 0 || !isIdentChar(sql[i-1])):
+			j := i + 1
+			for j < len(sql) && (isIdentChar(sql[j]) || sql[j] == '.' ||
+				(sql[j] == '+' || sql[j] == '-') && (sql[j-1] == 'e' || sql[j-1] == 'E')) {
+				j++
+			}
+			writeToken(&b, &space, "?")
+			i = j
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+		i++
+	}
+	fp := strings.TrimRight(b.String(), "; ")
+	fp = valueList.ReplaceAllString(fp, "(?+)")
+	return valueLists.ReplaceAllString(fp, "(?+)")
+}
+
+// skipQuoted 返回引号字符串结束后的位置，支持反斜杠转义和连续两个引号
+func skipQuoted(sql string, i int) int {
+	quote := sql[i]
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+func writeToken(b *strings.Builder, space *bool, token string) {
+	if *space {
+		b.WriteByte(' ')
+		*space = false
+	}
+	b.WriteString(token)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
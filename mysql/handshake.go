@@ -0,0 +1,146 @@
+package mysql
+
+// HandshakeV10 服务端在连接建立后发出的第一个包 (Protocol::HandshakeV10)
+type HandshakeV10 struct {
+	ProtocolVersion byte
+	ServerVersion   string
+	ConnectionID    uint32
+	Capabilities    uint32
+	Charset         byte
+	Status          uint16
+	AuthPlugin      string
+}
+
+// HandshakeResponse 客户端对握手包的响应 (Protocol::HandshakeResponse41 / 320)
+type HandshakeResponse struct {
+	Capabilities  uint32
+	MaxPacketSize uint32
+	Charset       byte
+	User          string
+	Database      string
+	AuthPlugin    string
+	Attrs         map[string]string
+	SSLRequest    bool // 只包含能力标志的 SSLRequest，之后的数据是 TLS
+}
+
+func parseHandshakeV10(data []byte) (*HandshakeV10, error) {
+	r := newFieldReader(data)
+	h := &HandshakeV10{ProtocolVersion: r.Byte()}
+	if h.ProtocolVersion != 10 {
+		return nil, ErrMalformPacket
+	}
+	h.ServerVersion = string(r.NullTerminatedString())
+	h.ConnectionID = r.Uint32()
+	r.Skip(8) // auth-plugin-data-part-1
+	r.Skip(1) // filler
+	h.Capabilities = uint32(r.Uint16())
+	if r.Len() == 0 {
+		return h, r.err
+	}
+	h.Charset = r.Byte()
+	h.Status = r.Uint16()
+	h.Capabilities |= uint32(r.Uint16()) << 16
+	authLen := int(r.Byte())
+	r.Skip(10) // reserved
+	if h.Capabilities&CLIENT_SECURE_CONNECTION != 0 {
+		r.Skip(max(13, authLen-8))
+	}
+	if h.Capabilities&CLIENT_PLUGIN_AUTH != 0 {
+		h.AuthPlugin = string(r.NullTerminatedString())
+	}
+	return h, r.err
+}
+
+func parseHandshakeResponse(data []byte) (*HandshakeResponse, error) {
+	r := newFieldReader(data)
+	h := &HandshakeResponse{}
+	if len(data) < 2 {
+		return nil, ErrMalformPacket
+	}
+
+	h.Capabilities = uint32(r.Uint16())
+	if h.Capabilities&CLIENT_PROTOCOL_41 == 0 {
+		// HandshakeResponse320
+		h.MaxPacketSize = r.Uint24()
+		h.User = string(r.NullTerminatedString())
+		if h.Capabilities&CLIENT_CONNECT_WITH_DB != 0 {
+			r.NullTerminatedString() // auth-response
+			h.Database = string(r.NullTerminatedString())
+		}
+		return h, r.err
+	}
+
+	h.Capabilities |= uint32(r.Uint16()) << 16
+	h.MaxPacketSize = r.Uint32()
+	h.Charset = r.Byte()
+	r.Skip(23) // filler
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.Len() == 0 && h.Capabilities&CLIENT_SSL != 0 {
+		h.SSLRequest = true
+		return h, nil
+	}
+	h.User = string(r.NullTerminatedString())
+
+	switch {
+	case h.Capabilities&CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+		r.LengthEncodedString()
+	case h.Capabilities&CLIENT_SECURE_CONNECTION != 0:
+		r.Skip(int(r.Byte()))
+	default:
+		r.NullTerminatedString()
+	}
+	if h.Capabilities&CLIENT_CONNECT_WITH_DB != 0 {
+		h.Database = string(r.NullTerminatedString())
+	}
+	if h.Capabilities&CLIENT_PLUGIN_AUTH != 0 {
+		h.AuthPlugin = string(r.NullTerminatedString())
+	}
+	if h.Capabilities&CLIENT_CONNECT_ATTRS != 0 && r.Len() > 0 {
+		h.Attrs = parseConnectAttrs(r)
+	}
+	return h, r.err
+}
+
+// parseConnectAttrs 解析 CLIENT_CONNECT_ATTRS 的键值对，如 program_name、_client_name、_pid
+func parseConnectAttrs(r *fieldReader) map[string]string {
+	n, _ := r.LengthEncodedInt()
+	if r.err != nil || n > uint64(r.Len()) {
+		return nil
+	}
+	ar := newFieldReader(r.Bytes(int(n)))
+	attrs := make(map[string]string)
+	for ar.Len() > 0 {
+		key, _ := ar.LengthEncodedString()
+		value, _ := ar.LengthEncodedString()
+		if ar.err != nil {
+			break
+		}
+		attrs[string(key)] = string(value)
+	}
+	return attrs
+}
+
+// parseChangeUser 解析 COM_CHANGE_USER (不含命令字节)，caps 为握手时协商的能力标志
+func parseChangeUser(data []byte, caps uint32) (*HandshakeResponse, error) {
+	r := newFieldReader(data)
+	h := &HandshakeResponse{Capabilities: caps}
+	h.User = string(r.NullTerminatedString())
+	if caps&CLIENT_SECURE_CONNECTION != 0 {
+		r.Skip(int(r.Byte()))
+	} else {
+		r.NullTerminatedString()
+	}
+	h.Database = string(r.NullTerminatedString())
+	if r.Len() >= 2 {
+		h.Charset = byte(r.Uint16())
+	}
+	if caps&CLIENT_PLUGIN_AUTH != 0 && r.Len() > 0 {
+		h.AuthPlugin = string(r.NullTerminatedString())
+	}
+	if caps&CLIENT_CONNECT_ATTRS != 0 && r.Len() > 0 {
+		h.Attrs = parseConnectAttrs(r)
+	}
+	return h, r.err
+}
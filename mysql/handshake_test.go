@@ -0,0 +1,30 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseHandshakeResponse(t *testing.T) {
+	caps := CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH |
+		CLIENT_CONNECT_WITH_DB | CLIENT_CONNECT_ATTRS
+	data := binary.LittleEndian.AppendUint32(nil, caps)
+	data = binary.LittleEndian.AppendUint32(data, 1<<24)
+	data = append(data, 45)
+	data = append(data, make([]byte, 23)...)
+	data = append(data, "app\x00"...)
+	data = append(data, 3, 'a', 'b', 'c')
+	data = append(data, "shop\x00mysql_native_password\x00"...)
+	attrs := append(lenencStr("program_name"), lenencStr("orders-api")...)
+	data = append(data, byte(len(attrs)))
+	data = append(data, attrs...)
+
+	h, err := parseHandshakeResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.User != "app" || h.Database != "shop" || h.AuthPlugin != "mysql_native_password" ||
+		h.Attrs["program_name"] != "orders-api" {
+		t.Fatalf("unexpected handshake response: %+v", h)
+	}
+}
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 )
 
@@ -63,3 +64,130 @@ func ReadStringFromByte(b []byte) (string, int) {
 	}
 	return string(b[:idx]), idx
 }
+
+// fieldReader 按顺序读取包内字段，越界时记录 ErrMalformPacket 并返回零值
+type fieldReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newFieldReader(data []byte) *fieldReader {
+	return &fieldReader{data: data}
+}
+
+func (r *fieldReader) Len() int {
+	return len(r.data) - r.pos
+}
+
+func (r *fieldReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.Len() < n {
+		r.err = ErrMalformPacket
+		r.pos = len(r.data)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *fieldReader) Byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *fieldReader) Uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *fieldReader) Uint24() uint32 {
+	if b := r.next(3); b != nil {
+		return uint32(getUint24(b))
+	}
+	return 0
+}
+
+func (r *fieldReader) Uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *fieldReader) Uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// LengthEncodedInt 读取长度编码整数，0xfb 表示 NULL
+func (r *fieldReader) LengthEncodedInt() (uint64, bool) {
+	b := r.Byte()
+	switch b {
+	case 0xfb:
+		return 0, true
+	case 0xfc:
+		return uint64(r.Uint16()), false
+	case 0xfd:
+		return uint64(r.Uint24()), false
+	case 0xfe:
+		return r.Uint64(), false
+	default:
+		return uint64(b), false
+	}
+}
+
+// LengthEncodedString 读取长度编码字符串
+func (r *fieldReader) LengthEncodedString() ([]byte, bool) {
+	num, isNull := r.LengthEncodedInt()
+	if isNull || r.err != nil {
+		return nil, isNull
+	}
+	if num > uint64(r.Len()) {
+		r.err = ErrMalformPacket
+		r.pos = len(r.data)
+		return nil, false
+	}
+	return r.next(int(num)), false
+}
+
+// NullTerminatedString 读取以 0 结尾的字符串，没有结尾时读到包尾
+func (r *fieldReader) NullTerminatedString() []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := r.data[r.pos:]
+	if idx := bytes.IndexByte(b, 0); idx != -1 {
+		r.pos += idx + 1
+		return b[:idx]
+	}
+	r.pos = len(r.data)
+	return b
+}
+
+func (r *fieldReader) Bytes(n int) []byte {
+	return r.next(n)
+}
+
+func (r *fieldReader) Skip(n int) {
+	r.next(n)
+}
+
+// Rest 读取剩余的全部字节
+func (r *fieldReader) Rest() []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := r.data[r.pos:]
+	r.pos = len(r.data)
+	return b
+}
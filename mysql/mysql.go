@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/JacksonChan-X/sql-sniffer/client"
+	"github.com/JacksonChan-X/sql-sniffer/authfail"
+	"github.com/JacksonChan-X/sql-sniffer/event"
 	"github.com/JacksonChan-X/sql-sniffer/helper"
 
 	"github.com/google/gopacket"
@@ -19,353 +21,879 @@ import (
 
 type MysqlStreamFactory struct {
 	Logger *logrus.Logger
+	Sink   event.Sink
 	Port   string
+	mysql  *Mysql
+	wg     sync.WaitGroup
 }
 
 type MysqlStream struct {
 	net, transport gopacket.Flow
-	buf            tcpreader.ReaderStream
+	buf            *helper.TimedStream
 }
 
+// maxStmtPerStream 每个连接最多登记的预编译语句数
+const maxStmtPerStream = 4096
+
 type Mysql struct {
-	Port      string
-	StreamMap map[string]*Stream
-	logger    *logrus.Logger
-	mutex     sync.Mutex
+	Port             string
+	StreamMap        map[string]*Stream
+	logger           *logrus.Logger
+	sink             event.Sink
+	slow             time.Duration // 只输出耗时超过该值的语句
+	longTx           time.Duration // 事务持续超过该值时告警
+	idleTx           time.Duration // 事务中空闲超过该值时告警
+	maxLongData      int           // 每个参数保留的 long data 字节数
+	maxStatementSize int           // 每个包保留的负载字节数
+	captureRows      int           // 每条语句保留的结果集行数
+	maxRowBytes      int           // 每行保留的字节数
+	errors           *errorStats   // 错误统计，为空时不输出汇总
+	activities       *activities   // 各连接的事务，死锁时查找并发的事务
+	clock            *clock        // 连接静默时使用的当前时间
+	mutex            sync.Mutex
+	wg               sync.WaitGroup
 }
 
+// Stream 一条 MySQL 连接，两个方向的包按抓包顺序进入 Packet
 type Stream struct {
-	ID        string
-	Packet    chan *Packet
-	StmtMap   map[uint32]*Statement
-	Seq       chan *Packet
-	needSeq   chan bool
-	logger    *logrus.Logger
-	privateIP string
-	publicIP  string
+	ID          string
+	Packet      chan *Packet
+	StmtMap     map[uint32]*Statement
+	logger      *logrus.Logger
+	sink        event.Sink
+	slow        time.Duration
+	longTx      time.Duration
+	idleTx      time.Duration
+	maxLongData int
+	captureRows int
+	maxRowBytes int
+	errors      *errorStats
+	activities  *activities
+	clock       *clock
+	clientAddr  string
+	serverAddr  string
+	refs        int      // 仍在读取该连接的方向数，归零时关闭Packet
+	pending     *command // 等待服务端响应的命令
+	compression *compression
+	secure      *secure
+
+	handshake *HandshakeV10      // 服务端握手包，抓包开始时连接已建立则为空
+	login     *HandshakeResponse // 客户端握手响应
+	encrypted *event.Event       // SSLRequest 之后等待 TLS 中的握手响应，无法解密时在连接结束时输出
+	conn      *event.ConnInfo
+	user      string
+	database  string
+	session   *event.Session // 当前会话状态，变化时整体替换
+	tx        *transaction   // 正在进行的事务
+}
+
+// command 已发出、正在等待服务端响应的命令
+type command struct {
+	cmd      byte
+	event    *event.Event
+	stmt     *Statement // COM_STMT_PREPARE 的语句，收到响应后登记
+	response *Response
+	last     time.Time          // 最后一个响应包的抓包时间
+	login    *HandshakeResponse // COM_CHANGE_USER 的新用户
 }
 
 type Packet struct {
 	IsClientFlow bool
 	Seq          uint8
-	Length       int
+	Length       int // 负载的原始长度
 	Payload      []byte
+	Truncated    bool      // 负载超过 maxStatementSize，只保留了前面部分
+	Timestamp    time.Time // 包首字节的抓包时间
 }
 
-var (
-	mysql *Mysql
-	once  sync.Once
-)
+// clock 连接静默时判断事务空闲和命令超时的当前时间。
+// 实时抓包时为本地时间，回放时为所有连接中最新的抓包时间，与回放的速度无关
+type clock struct {
+	replay bool
+	nanos  atomic.Int64 // 最新的抓包时间
+}
 
-func NewInstance(port string, logger *logrus.Logger) *Mysql {
-	once.Do(func() {
-		mysql = &Mysql{
-			Port:      port,
-			StreamMap: make(map[string]*Stream),
-			logger:    logger,
-			mutex:     sync.Mutex{},
+func (c *clock) advance(ts time.Time) {
+	n := ts.UnixNano()
+	for {
+		cur := c.nanos.Load()
+		if n <= cur || c.nanos.CompareAndSwap(cur, n) {
+			return
 		}
-	})
-	return mysql
+	}
+}
+
+// Now 返回当前时间，回放时还没有包为零值
+func (c *clock) Now() time.Time {
+	if !c.replay {
+		return time.Now()
+	}
+	if n := c.nanos.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+func NewInstance(port string, logger *logrus.Logger, sink event.Sink) *Mysql {
+	return &Mysql{
+		Port:       port,
+		StreamMap:  make(map[string]*Stream),
+		logger:     logger,
+		sink:       sink,
+		activities: newActivities(),
+		clock:      &clock{},
+		mutex:      sync.Mutex{},
+	}
+}
+
+func NewMysqlStreamFactory(port string, logger *logrus.Logger, sink event.Sink) *MysqlStreamFactory {
+	return &MysqlStreamFactory{
+		Logger: logger,
+		Sink:   sink,
+		Port:   port,
+		mysql:  NewInstance(port, logger, sink),
+	}
 }
 
 func (p *MysqlStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
 	ps := &MysqlStream{
 		net:       net,
 		transport: transport,
-		buf:       tcpreader.NewReaderStream(),
+		buf:       helper.NewTimedStream(),
 	}
 
-	mysqlInstance := NewInstance(p.Port, p.Logger)
-	go mysqlInstance.ResolveStream(net, transport, &ps.buf)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.mysql.ResolveStream(net, transport, ps.buf)
+	}()
 
-	return &ps.buf
+	return ps.buf
 }
 
-func (m *Mysql) ResolveStream(net, transport gopacket.Flow, buf io.Reader) {
+// Wait 等待所有流的解析协程退出，需在 assembler FlushAll 之后调用
+func (p *MysqlStreamFactory) Wait() {
+	p.wg.Wait()
+	p.mysql.wg.Wait()
+	if p.mysql.errors != nil {
+		p.mysql.errors.release()
+	}
+}
+
+func (m *Mysql) ResolveStream(net, transport gopacket.Flow, buf *helper.TimedStream) {
 	streamID := fmt.Sprintf("%v:%v", net.FastHash(), transport.FastHash())
 
 	m.mutex.Lock()
-	if _, ok := m.StreamMap[streamID]; !ok {
-		stream := &Stream{
-			ID:      streamID,
-			Packet:  make(chan *Packet, 100),
-			StmtMap: make(map[uint32]*Statement, 0),
-			logger:  m.logger,
-			Seq:     make(chan *Packet, 100),
-			needSeq: make(chan bool, 100),
+	stream, ok := m.StreamMap[streamID]
+	if !ok {
+		stream = &Stream{
+			ID:          streamID,
+			Packet:      make(chan *Packet, 100),
+			StmtMap:     make(map[uint32]*Statement, 0),
+			logger:      m.logger,
+			sink:        m.sink,
+			slow:        m.slow,
+			longTx:      m.longTx,
+			idleTx:      m.idleTx,
+			maxLongData: m.maxLongData,
+			captureRows: m.captureRows,
+			maxRowBytes: m.maxRowBytes,
+			errors:      m.errors,
+			activities:  m.activities,
+			clock:       m.clock,
+			compression: &compression{},
+			secure:      newSecure(),
 		}
+		src, dst := helper.FlowAddr(net, transport)
 		if transport.Dst().String() == m.Port {
-			stream.privateIP = net.Dst().String()
-			stream.publicIP = net.Src().String()
+			stream.clientAddr, stream.serverAddr = src, dst
 		} else {
-			stream.privateIP = net.Src().String()
-			stream.publicIP = net.Dst().String()
+			stream.clientAddr, stream.serverAddr = dst, src
 		}
 		m.StreamMap[streamID] = stream
-		m.mutex.Unlock()
-		go stream.run()
-	} else {
-		m.mutex.Unlock()
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			stream.run()
+		}()
 	}
+	stream.refs++
+	m.mutex.Unlock()
 
+	client := transport.Src().String() != m.Port
+	pr := newPacketReader(buf, client, stream.compression, stream.secure)
+	login := client     // 客户端方向的第一个包是握手响应
+	greeting := !client // 服务端方向的第一个包是握手包
 	for {
 		// 解析新包
-		newPacket := m.newPacket(net, transport, buf)
+		newPacket := m.newPacket(net, transport, pr)
 		if newPacket == nil {
-			if transport.Src().String() == m.Port {
-				m.mutex.Lock()
-				close(m.StreamMap[streamID].Seq)
-				m.mutex.Unlock()
-			} else {
-				m.mutex.Lock()
-				close(m.StreamMap[streamID].Packet)
-				m.mutex.Unlock()
+			// 丢弃剩余数据，避免阻塞 assembler
+			tcpreader.DiscardBytesToEOF(buf)
+
+			m.mutex.Lock()
+			stream.refs--
+			if stream.refs == 0 {
+				close(stream.Packet)
+				delete(m.StreamMap, streamID)
 			}
+			m.mutex.Unlock()
 			return
 		}
-
 		if newPacket.IsClientFlow {
-			m.mutex.Lock()
-			if cmd := newPacket.Payload[0]; cmd == COM_STMT_PREPARE {
-				m.StreamMap[streamID].needSeq <- true
+			if login {
+				login = m.detectLogin(stream.compression, stream.secure, newPacket)
 			}
-			m.StreamMap[streamID].Packet <- newPacket // 客户端包
-			m.mutex.Unlock()
 		} else {
-			if newPacket.Seq != 1 {
-				continue
+			if greeting {
+				greeting = false
+				m.detectGreeting(stream.compression, newPacket)
 			}
-			// m.logger.Warn("seq == 1")
-			m.mutex.Lock()
-			select {
-			case need, ok := <-m.StreamMap[streamID].needSeq:
-				// m.logger.Warn("receive")
-				if !ok {
-					continue
-				}
-				if need {
-					m.StreamMap[streamID].Seq <- newPacket
-					// m.logger.Warn(fmt.Sprintf("%+v", newPacket))
-				}
-			default:
-			}
-			m.mutex.Unlock()
+			m.detectCompression(stream.compression, newPacket)
 		}
+
+		// 读下一个包之前送入通道，ReaderStream 在下一次 Read 时才放行 assembler，
+		// 所以两个方向的包在通道中保持抓包顺序
+		m.clock.advance(newPacket.Timestamp)
+		stream.Packet <- newPacket
 	}
 }
 
-func (m *Mysql) newPacket(net, transport gopacket.Flow, r io.Reader) *Packet {
-
-	//read packet
-	var payload *bytes.Buffer
-	var seq uint8
-	var err error
-	if seq, payload, err = m.resolvePacket(r); err != nil {
-		if err == io.EOF {
-			m.logger.Info(fmt.Sprintf("stream:%s close",
-				net.Src().String()+":"+transport.Src().String()+":"+
-					net.Dst().String()+":"+transport.Dst().String(),
-			))
-			return nil
-		} else {
-			m.logger.Error(fmt.Printf("ERR : Unknown Packet, stream:%s,err:%s",
-				net.Src().String()+":"+transport.Src().String()+":"+net.Dst().String()+":"+transport.Dst().String(),
-				err,
-			))
-		}
+// detectGreeting 记录服务端握手包的能力标志，压缩算法需要双方都支持
+func (m *Mysql) detectGreeting(c *compression, p *Packet) {
+	if p.Seq != 0 {
+		return
+	}
+	if h, err := parseHandshakeV10(p.Payload); err == nil {
+		c.offer(h.Capabilities)
 	}
+}
 
-	//generate new packet
-	var pk = Packet{
-		Seq:     seq,
-		Length:  payload.Len(),
-		Payload: payload.Bytes(),
+// detectLogin 从客户端握手响应中得到压缩算法，SSLRequest 之后两个方向都切换为 TLS。
+// 返回是否还要等待 TLS 中的握手响应
+func (m *Mysql) detectLogin(c *compression, s *secure, p *Packet) bool {
+	if len(p.Payload) == 0 || p.Seq != 1 && (p.Seq != 2 || !s.enabled.Load()) {
+		return false
 	}
-	if transport.Src().String() == m.Port {
-		pk.IsClientFlow = false
-	} else {
-		pk.IsClientFlow = true
+	login, err := parseHandshakeResponse(p.Payload)
+	if err != nil {
+		return false
+	}
+	if login.SSLRequest {
+		s.enabled.Store(true)
+		return true
 	}
+	c.negotiate(login.Capabilities)
+	return false
+}
 
-	return &pk
+// detectCompression 客户端握手响应协商了压缩时，服务端认证成功的 OK 包之后开启压缩
+func (m *Mysql) detectCompression(c *compression, p *Packet) {
+	if c.enabled.Load() || len(p.Payload) == 0 {
+		return
+	}
+	if c.algorithm.Load() != compressNone && p.Seq >= 2 && p.Payload[0] == iOK {
+		c.enabled.Store(true)
+	}
 }
 
-func (m *Mysql) resolvePacket(r io.Reader) (uint8, *bytes.Buffer, error) {
-	header := make([]byte, 4)
-	if n, err := io.ReadFull(r, header); err != nil {
-		if n == 0 && err == io.EOF {
-			return 0, nil, io.EOF
+func (m *Mysql) newPacket(net, transport gopacket.Flow, r *packetReader) *Packet {
+
+	//read packet
+	var payload *bytes.Buffer
+	var seq uint8
+	var err error
+	if err = r.wait(); err == nil {
+		start := r.Offset()
+		var length int
+		if seq, payload, length, err = m.resolvePacket(r); err == nil {
+			//generate new packet
+			var pk = Packet{
+				Seq:       seq,
+				Length:    length,
+				Payload:   payload.Bytes(),
+				Truncated: payload.Len() < length,
+				Timestamp: r.Seen(start),
+			}
+			if transport.Src().String() == m.Port {
+				pk.IsClientFlow = false
+			} else {
+				pk.IsClientFlow = true
+			}
+			return &pk
 		}
-		return 0, nil, ErrorStream
 	}
-	length := getUint24(header[0:3])
-	seq := header[3]
-	payload := new(bytes.Buffer)
-	n, err := io.CopyN(payload, r, int64(length))
-	if err != nil {
-		return 0, nil, err
+	if err == io.EOF {
+		m.logger.Info(fmt.Sprintf("stream:%s close",
+			net.Src().String()+":"+transport.Src().String()+":"+
+				net.Dst().String()+":"+transport.Dst().String(),
+		))
+		return nil
 	}
-	if n != int64(length) {
-		return 0, nil, ErrorStream
+	if r.encrypted {
+		m.logger.Warn(fmt.Sprintf("ERR : Could not decrypt TLS, stream:%s,err:%s",
+			net.Src().String()+":"+transport.Src().String()+":"+net.Dst().String()+":"+transport.Dst().String(),
+			err,
+		))
+		return nil
 	}
-	return seq, payload, nil
+	m.logger.Error(fmt.Sprintf("ERR : Unknown Packet, stream:%s,err:%s",
+		net.Src().String()+":"+transport.Src().String()+":"+net.Dst().String()+":"+transport.Dst().String(),
+		err,
+	))
+	return nil
 }
 
-func (stm *Stream) run() error {
-	for {
-		select {
-		case Packet, ok := <-stm.Packet:
-			if !ok {
-				return nil
+// maxPayloadLength 单个包的最大长度，等于该长度时后面还有续包
+const maxPayloadLength = 0xffffff
+
+// wireSize 负载在网络上占用的字节数，包括每个包 4 字节的包头
+func wireSize(length int) int {
+	return length + 4*(length/maxPayloadLength+1)
+}
+
+// resolvePacket 读取一个完整的包，超过 16MB 的负载被拆成多个包时合并续包。
+// 只保留前 maxStatementSize 字节，返回值 length 为负载的原始长度
+func (m *Mysql) resolvePacket(r io.Reader) (uint8, *bytes.Buffer, int, error) {
+	var (
+		seq     uint8
+		length  int
+		payload = new(bytes.Buffer)
+		header  = make([]byte, 4)
+	)
+	for i := 0; ; i++ {
+		if n, err := io.ReadFull(r, header); err != nil {
+			if i == 0 && n == 0 && err == io.EOF {
+				return 0, nil, 0, io.EOF
 			}
-			if Packet.Length != 0 {
-				if Packet.IsClientFlow {
-					stm.resolveClientPacket(Packet)
-				} else {
-					// go stm.resolveServerPacket(Packet)
-				}
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return 0, nil, 0, err // TLS 解密或解压失败
 			}
-		case <-time.After(time.Minute * 5): // 5分钟没有数据包，则认为连接断开
-			return ErrTimeOut
+			return 0, nil, 0, ErrorStream
+		}
+		if i == 0 {
+			seq = header[3]
+		}
+		size := getUint24(header[0:3])
+		length += size
+
+		keep := size
+		if m.maxStatementSize > 0 {
+			keep = min(size, max(m.maxStatementSize-payload.Len(), 0))
+		}
+		n, err := io.CopyN(payload, r, int64(keep))
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		if n != int64(keep) {
+			return 0, nil, 0, ErrorStream
+		}
+		if keep < size {
+			if _, err = io.CopyN(io.Discard, r, int64(size-keep)); err != nil {
+				return 0, nil, 0, err
+			}
+		}
+		if size < maxPayloadLength {
+			return seq, payload, length, nil
 		}
 	}
 }
 
-func (stm *Stream) findStmtPacket(seq uint8) *Packet {
+func (stm *Stream) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastPacket time.Time // 该连接最后一个包的抓包时间
 	for {
 		select {
-		case packet, ok := <-stm.Seq:
+		case packet, ok := <-stm.Packet:
 			if !ok {
-				return nil
+				stm.finish()
+				// 回放结束或停止抓包时，先检查仍未结束的事务是否已经空闲或持续过久
+				stm.checkTransaction(stm.clock.Now())
+				stm.endTransaction(txDisconnected)
+				stm.unpublish()
+				if e := stm.encrypted; e != nil {
+					// 没有密钥，只能输出连接使用了 TLS
+					e.Conn.TLS = stm.secure.conn.String()
+					stm.sink.Emit(e)
+				}
+				return
 			}
-			if !packet.IsClientFlow && packet.Seq == seq {
-				return packet
-			} else {
+			lastPacket = packet.Timestamp
+			if packet.Length == 0 {
 				continue
 			}
-		case <-time.After(10 * time.Second):
-			stm.logger.Warn("timeout")
-			return nil
+			if packet.IsClientFlow {
+				stm.resolveClientPacket(packet)
+			} else {
+				stm.resolveServerPacket(packet)
+			}
+		case <-ticker.C:
+			now := stm.clock.Now()
+			if !lastPacket.IsZero() && now.Sub(lastPacket) >= time.Minute*5 { // 5分钟没有数据包，不再等待当前命令的响应
+				stm.finish()
+			}
+			stm.checkTransaction(now)
 		}
 	}
 }
 
+func (stm *Stream) newEvent(p *Packet, cmd byte) *event.Event {
+	return &event.Event{
+		Protocol:   event.ProtocolMySQL,
+		ClientAddr: stm.clientAddr,
+		ServerAddr: stm.serverAddr,
+		ConnID:     stm.ID,
+		Command:    CommandName(cmd),
+		Timestamp:  p.Timestamp,
+		BytesIn:    wireSize(p.Length),
+		User:       stm.user,
+		Database:   stm.database,
+		Conn:       stm.conn,
+		Session:    stm.session,
+	}
+}
+
 func (stm *Stream) resolveClientPacket(p *Packet) {
 	payload := p.Payload
-	seq := p.Seq
 
-	var msg = ""
-	if len(payload) == 0 {
+	// 非0序号的客户端包属于当前命令 (认证数据、LOCAL INFILE 文件内容等)
+	if p.Seq != 0 {
+		// 使用 TLS 时 SSLRequest 的序号为 1，TLS 中的握手响应序号为 2
+		login := p.Seq == 1 || p.Seq == 2 && stm.secure.enabled.Load()
+		if login && stm.handshake != nil && stm.login == nil {
+			stm.resolveLogin(p)
+		} else if stm.pending != nil {
+			stm.pending.event.BytesIn += wireSize(p.Length)
+		}
 		return
 	}
 
+	// 新命令开始，上一条命令即使没有等到响应也先输出
+	stm.finish()
+
 	cmd := payload[0]
 	data := payload[1:]
+	e := stm.newEvent(p, cmd)
+	c := &command{cmd: cmd, event: e}
 	switch cmd {
 	case COM_INIT_DB:
-		msg = fmt.Sprintf("USE %s;\n", data)
+		e.Database = string(data)
+		e.Statement = fmt.Sprintf("USE %s;", data)
 	case COM_DROP_DB:
-		msg = fmt.Sprintf("Drop DB %s;\n", data)
+		e.Statement = fmt.Sprintf("Drop DB %s;", data)
 	case COM_CREATE_DB, COM_QUERY:
-		msg = string(data)
+		e.Statement = statementText(p, data)
 	case COM_STMT_PREPARE:
-		serverPacket := stm.findStmtPacket(seq + 1)
-		if serverPacket == nil {
-			stmt := &Statement{
-				SQL:        string(data),
-				ParamCount: helper.GetParamCount(string(data)),
-			}
-			stmt.Args = make([]any, stmt.ParamCount)
-			stm.StmtMap[0] = stmt
-			stm.logger.Error(fmt.Sprintf("ERR : Not found seq:%d,sql:%s", seq+1, string(data)))
+		e.Statement = statementText(p, data)
+		c.stmt = &Statement{SQL: e.Statement}
+	case COM_STMT_EXECUTE:
+		pos := 1
+		if len(payload) < pos+4 {
+			stm.logger.Warn("ERR:Malform packet error")
 			return
 		}
-
-		stmtID := binary.LittleEndian.Uint32(serverPacket.Payload[1:5])
-		stmt := &Statement{
-			ID:         stmtID,
-			SQL:        string(data),
-			FieldCount: binary.LittleEndian.Uint16(serverPacket.Payload[5:7]),
-			ParamCount: binary.LittleEndian.Uint16(serverPacket.Payload[7:9]),
-		}
-		stmt.Args = make([]any, stmt.ParamCount)
-		stm.StmtMap[stmtID] = stmt
-	case COM_STMT_EXECUTE:
-		var (
-			ok       bool
-			stmt, ts *Statement
-			pos      = 1
-		)
 		stmtID := binary.LittleEndian.Uint32(payload[pos : pos+4])
-		stmt, ok = stm.StmtMap[stmtID]
-		if !ok {
-			if ts, ok = stm.StmtMap[0]; ok {
-				stmt = ts
-			} else {
-				stm.logger.Error(fmt.Sprintf("ERR : Not found stmtID:%d", stmtID))
+		stmt := stm.lookupStmt(stmtID)
+		if stmt == nil {
+			// 抓包开始前 prepare 的语句，按报文中的类型解析参数
+			if len(data) < 9 {
+				stm.logger.Warn("ERR:Malform packet error")
 				return
 			}
+			if stmt = guessStmt(stmtID, data[9:]); stmt == nil {
+				e.Statement = fmt.Sprintf("unknown stmt %d", stmtID)
+				break
+			}
+			stm.addStmt(stmt)
+		}
+		if p.Truncated {
+			stm.logger.Warn(fmt.Sprintf("ERR : Params truncated, stmtID:%d,length:%d", stmtID, p.Length))
 		}
+		// 执行后 long data 失效
+		defer stmt.ResetLongData()
 		pos = 5 // pos = 5
 
 		var nullBitmaps, paramTypes, paramValues []byte
 		pos += 4
+		e.Statement = stmt.SQL
 		if stmt.ParamCount > 0 {
 			nullBitmapLen := (stmt.ParamCount + 7) >> 3
 			if len(data) < (pos + int(nullBitmapLen) + 1) {
 				stm.logger.Warn("ERR:Malform packet error")
+				break
 			}
 			nullBitmaps = data[pos : pos+int(nullBitmapLen)]
 			pos += int(nullBitmapLen)
 
-			// new param bound flag
+			// new param bound flag，为 0 时沿用上一次执行的参数类型
 			if data[pos] == 1 {
 				pos++
 				if len(data) < (pos + int(stmt.ParamCount<<1)) {
 					stm.logger.Warn("ERR:Malform packet error")
+					break
 				}
 
 				paramTypes = data[pos : pos+int(stmt.ParamCount<<1)]
 				pos += int(stmt.ParamCount << 1)
+				stmt.ParamTypes = append(stmt.ParamTypes[:0], paramTypes...)
+			} else {
+				pos++
+				paramTypes = stmt.ParamTypes
+			}
+			if paramTypes == nil {
+				stm.logger.Warn(fmt.Sprintf("ERR : Not found param types, stmtID:%d", stmt.ID))
+				break
+			}
 
-				paramValues = data[pos:]
+			paramValues = data[pos:]
 
-				if err := stmt.BindStmtArgs(nullBitmaps, paramTypes, paramValues); err != nil {
-					stm.logger.Error(fmt.Sprintf("ERR : Could not bind params,%s", err.Error()))
-				}
+			if err := stmt.BindStmtArgs(nullBitmaps, paramTypes, paramValues); err != nil {
+				stm.logger.Error(fmt.Sprintf("ERR : Could not bind params,%s", err.Error()))
 			}
-			msg = client.ExplainSQL(stmt.SQL, nil, `'`, stmt.Args...)
+			e.Args = append([]any(nil), stmt.Args...)
 		}
+	case COM_STMT_RESET:
+		if len(data) < 4 {
+			stm.logger.Warn("ERR:Malform packet error")
+			return
+		}
+		stmtID := binary.LittleEndian.Uint32(data[0:4])
+		if stmt := stm.lookupStmt(stmtID); stmt != nil {
+			stmt.ResetLongData()
+		}
+		e.Statement = fmt.Sprintf("Reset stmtID:%d", stmtID)
+	case COM_STMT_SEND_LONG_DATA:
+		// 没有响应，数据在下一次执行时合并到参数中
+		if len(data) < 6 {
+			stm.logger.Warn("ERR:Malform packet error")
+			return
+		}
+		stmtID := binary.LittleEndian.Uint32(data[0:4])
+		stmt := stm.lookupStmt(stmtID)
+		if stmt == nil {
+			stm.logger.Error(fmt.Sprintf("ERR : Not found stmtID:%d", stmtID))
+			return
+		}
+		paramID := binary.LittleEndian.Uint16(data[4:6])
+		if paramID >= stmt.ParamCount {
+			stm.logger.Warn(fmt.Sprintf("ERR : Invalid param id:%d, stmtID:%d", paramID, stmtID))
+			return
+		}
+		stmt.AppendLongData(paramID, data[6:], stm.maxLongData)
+		return
+	case COM_PING, COM_STATISTICS, COM_FIELD_LIST, COM_STMT_FETCH,
+		COM_RESET_CONNECTION, COM_SET_OPTION:
+		e.Statement = CommandName(cmd)
+	case COM_CHANGE_USER:
+		var caps uint32 = CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION
+		if stm.conn != nil {
+			caps = stm.conn.Capabilities
+		}
+		login, err := parseChangeUser(data, caps)
+		if err != nil {
+			stm.logger.Warn(fmt.Sprintf("ERR : Could not parse change user, stream:%s,err:%s", stm.ID, err))
+		}
+		c.login = login
+		e.Statement = fmt.Sprintf("Change user:%s,db:%s", login.User, login.Database)
 	case COM_QUIT:
-		msg = fmt.Sprintf("QUIT stream:%s", stm.ID)
+		e.Statement = "QUIT"
+		stm.emit(e)
+		return
 	case COM_STMT_CLOSE:
-		stmtID := binary.LittleEndian.Uint32(payload[1:5])
-		msg = fmt.Sprintf("Close,stream:%s,stmtID:%d", stm.ID, stmtID)
+		if len(data) < 4 {
+			stm.logger.Warn("ERR:Malform packet error")
+			return
+		}
+		stmtID := binary.LittleEndian.Uint32(data[0:4])
+		delete(stm.StmtMap, stmtID)
+		e.Statement = fmt.Sprintf("Close stmtID:%d", stmtID)
+		// 没有响应
+		stm.emit(e)
+		return
 	default:
 		return
 	}
 
-	stm.logger.Info(stm.publicIP + ":" + stm.privateIP + " " + msg)
-}
-
-// func (stm *Stream) resolveServerPacket(p *Packet) {
-// 	if len(p.Payload) == 0 {
-// 		return
-// 	}
-
-// 	cmd := p.Payload[0]
-// 	switch cmd {
-// 	case 0x00:
-// 		stmtID := binary.LittleEndian.Uint32(p.Payload[1:5])
-// 		if stmtID == stm.curStmtID {
-// 			stm.logger.Info(fmt.Sprintf("success stmtID:%d,stream:%s,packet:%+v", stmtID, stm.ID, p))
-// 			stm.curStmtID += 1
-// 			stm.Seq <- p
-// 		} else {
-// 			stm.logger.Warn(fmt.Sprintf("ERR : Not found stmtID:%d,stream:%s,packet:%+v", stmtID, stm.ID, p))
-// 		}
-// 	default:
-// 		return
-// 	}
-// }
+	c.response = stm.newResponse(cmd)
+	if cmd == COM_STMT_FETCH && len(data) >= 4 {
+		// 游标返回的行没有列定义，使用 prepare 时的列定义
+		if stmt := stm.lookupStmt(binary.LittleEndian.Uint32(data[0:4])); stmt != nil {
+			c.response.Columns = append([]*ColumnDefinition(nil), stmt.Columns...)
+		}
+	}
+	stm.pending = c
+	stm.publish(p.Timestamp)
+}
+
+func (stm *Stream) newResponse(cmd byte) *Response {
+	r := newResponse(cmd)
+	r.SessionTrack = stm.conn != nil && stm.conn.Capabilities&CLIENT_SESSION_TRACK != 0
+	r.CaptureRows = stm.captureRows
+	r.MaxRowBytes = stm.maxRowBytes
+	return r
+}
+
+// statementText 返回语句文本，负载被截断时加上截断标记
+func statementText(p *Packet, data []byte) string {
+	if p.Truncated {
+		return event.Truncated{Data: data, Size: p.Length - 1}.String()
+	}
+	return string(data)
+}
+
+func (stm *Stream) resolveServerPacket(p *Packet) {
+	c := stm.pending
+	if c == nil {
+		if p.Seq == 0 && stm.handshake == nil && stm.login == nil {
+			stm.resolveHandshake(p)
+		}
+		return
+	}
+	c.last = p.Timestamp
+	done, err := c.response.Feed(p.Payload, p.Length)
+	if err != nil {
+		stm.logger.WithTime(p.Timestamp).Warn(fmt.Sprintf("ERR : Could not parse response, stream:%s,err:%s", stm.ID, err))
+	}
+	if done {
+		stm.finish()
+	}
+}
+
+func (stm *Stream) lookupStmt(id uint32) *Statement {
+	return stm.StmtMap[id]
+}
+
+// addStmt 登记预编译语句，超过上限时淘汰 ID 最小(最早)的语句
+func (stm *Stream) addStmt(stmt *Statement) {
+	if _, ok := stm.StmtMap[stmt.ID]; !ok && len(stm.StmtMap) >= maxStmtPerStream {
+		oldest := stmt.ID
+		for id := range stm.StmtMap {
+			oldest = min(oldest, id)
+		}
+		delete(stm.StmtMap, oldest)
+		stm.logger.Warn(fmt.Sprintf("ERR : Too many statements, stream:%s,drop stmtID:%d", stm.ID, oldest))
+	}
+	stm.StmtMap[stmt.ID] = stmt
+}
+
+// resolveHandshake 解析服务端握手包，记录版本、连接ID和能力标志
+func (stm *Stream) resolveHandshake(p *Packet) {
+	h, err := parseHandshakeV10(p.Payload)
+	if err != nil {
+		stm.logger.WithTime(p.Timestamp).Warn(fmt.Sprintf("ERR : Could not parse handshake, stream:%s,err:%s", stm.ID, err))
+		return
+	}
+	stm.handshake = h
+}
+
+// resolveLogin 解析客户端握手响应，之后的语句都带上用户、库和连接属性
+func (stm *Stream) resolveLogin(p *Packet) {
+	login, err := parseHandshakeResponse(p.Payload)
+	if err != nil {
+		stm.logger.WithTime(p.Timestamp).Warn(fmt.Sprintf("ERR : Could not parse handshake response, stream:%s,err:%s", stm.ID, err))
+		return
+	}
+	if login.SSLRequest {
+		stm.logger.WithTime(p.Timestamp).Info(fmt.Sprintf("stream:%s use TLS", stm.ID))
+		e := stm.newEvent(p, COM_CONNECT)
+		e.Statement = "Connect TLS"
+		e.Conn = &event.ConnInfo{
+			ServerVersion: stm.handshake.ServerVersion,
+			ThreadID:      stm.handshake.ConnectionID,
+			Capabilities:  login.Capabilities & stm.handshake.Capabilities,
+			Charset:       login.Charset,
+		}
+		stm.encrypted = e
+		return
+	}
+	stm.login = login
+	stm.encrypted = nil
+
+	stm.user = login.User
+	stm.database = login.Database
+	stm.conn = &event.ConnInfo{
+		ServerVersion: stm.handshake.ServerVersion,
+		ThreadID:      stm.handshake.ConnectionID,
+		Capabilities:  login.Capabilities & stm.handshake.Capabilities,
+		Charset:       login.Charset,
+		AuthPlugin:    login.AuthPlugin,
+		Attrs:         login.Attrs,
+	}
+	if len(stm.conn.AuthPlugin) == 0 {
+		stm.conn.AuthPlugin = stm.handshake.AuthPlugin
+	}
+	if stm.secure.enabled.Load() {
+		stm.conn.TLS = stm.secure.conn.String()
+	}
+
+	e := stm.newEvent(p, COM_CONNECT)
+	e.Statement = fmt.Sprintf("Connect user:%s,db:%s", login.User, login.Database)
+	stm.pending = &command{cmd: COM_CONNECT, event: e, response: stm.newResponse(COM_CONNECT)}
+}
+
+// finish 用已收到的响应填充当前命令的事件并输出
+func (stm *Stream) finish() {
+	c := stm.pending
+	if c == nil {
+		return
+	}
+	stm.pending = nil
+
+	e, resp := c.event, c.response
+	e.BytesOut = resp.Bytes
+	if !c.last.IsZero() {
+		e.Latency = c.last.Sub(e.Timestamp)
+	}
+	switch {
+	case resp.Err != nil:
+		e.Result = event.ResultError
+		e.ErrorCode = int(resp.Err.Code)
+		e.SQLState = resp.Err.SQLState
+		e.ErrorMessage = resp.Err.Message
+		stm.recordError(e)
+	case resp.ResultSet || resp.cmd == COM_FIELD_LIST:
+		e.Result = event.ResultResultSet
+		e.Rows = resp.Rows
+		e.Warnings = int(resp.Warnings)
+		e.ServerStatus = resp.Status
+	case resp.OK != nil:
+		e.Result = event.ResultOK
+		e.AffectedRows = int64(resp.OK.AffectedRows)
+		e.LastInsertID = resp.OK.LastInsertID
+		e.Warnings = int(resp.OK.Warnings)
+		e.ServerStatus = resp.OK.Status
+	case resp.Packets > 0:
+		e.Result = event.ResultOK
+		e.Warnings = int(resp.Warnings)
+		e.ServerStatus = resp.Status
+	}
+	for _, col := range resp.Columns {
+		e.Columns = append(e.Columns, event.Column{
+			Name:  col.Name,
+			Table: col.Table,
+			Type:  TypeName(col.Type),
+		})
+	}
+	e.RowData = resp.RowData
+
+	if c.stmt != nil {
+		if resp.Prepare != nil {
+			stmt := c.stmt
+			stmt.ID = resp.Prepare.StmtID
+			stmt.FieldCount = resp.Prepare.NumColumns
+			stmt.ParamCount = resp.Prepare.NumParams
+			stmt.Args = make([]any, stmt.ParamCount)
+			stmt.Columns = resp.Columns
+			stm.addStmt(stmt)
+		} else if resp.Err == nil {
+			stm.logger.WithTime(e.Timestamp).Error(fmt.Sprintf("ERR : Not found prepare response, sql:%s", c.stmt.SQL))
+		}
+	}
+
+	// 重置连接和切换用户会释放连接上的全部预编译语句
+	if resp.OK != nil && (c.cmd == COM_RESET_CONNECTION || c.cmd == COM_CHANGE_USER) {
+		stm.StmtMap = make(map[uint32]*Statement)
+		if c.login != nil {
+			stm.user, stm.database = c.login.User, c.login.Database
+		}
+	}
+	stm.updateSession(c, resp)
+
+	stm.emit(e)
+	if resp.Err != nil && (c.cmd == COM_CONNECT || c.cmd == COM_CHANGE_USER) {
+		stm.observeLogin(c, e, resp.Err.Code)
+	}
+	stm.trackTransaction(c, resp)
+	if e.ErrorClass == errDeadlock && resp.Err.Code == 1213 {
+		// 死锁时服务端回滚整个事务
+		stm.endTransaction(txRollback)
+	}
+	stm.publish(e.Timestamp.Add(e.Latency))
+}
+
+// observeLogin 统计认证失败，达到阈值时输出告警
+func (stm *Stream) observeLogin(c *command, e *event.Event, code uint16) {
+	if code != 1045 && code != 1698 {
+		return
+	}
+	failed := *e
+	if c.login != nil {
+		failed.User = c.login.User // 切换用户失败时统计新用户
+	}
+	for _, alert := range authfail.Observe(&failed) {
+		stm.sink.Emit(alert)
+	}
+}
+
+// updateSession 根据成功执行的命令和响应中的状态标志更新会话状态，之后的语句带上新状态
+func (stm *Stream) updateSession(c *command, resp *Response) {
+	if resp.Err != nil || resp.Packets == 0 {
+		return
+	}
+
+	var session event.Session
+	if stm.session != nil {
+		session = *stm.session
+	}
+	changed := false
+	if resp.HasStatus {
+		inTrans := resp.Status&SERVER_STATUS_IN_TRANS != 0
+		autocommit := resp.Status&SERVER_STATUS_AUTOCOMMIT != 0
+		if stm.session == nil || session.InTransaction != inTrans || session.Autocommit != autocommit {
+			session.InTransaction, session.Autocommit = inTrans, autocommit
+			changed = true
+		}
+	}
+
+	vars := resp.Variables
+	switch c.cmd {
+	case COM_INIT_DB:
+		stm.database = c.event.Database
+	case COM_QUERY:
+		if db, ok := parseUseStatement(c.event.Statement); ok {
+			stm.database = db
+		}
+		for name, value := range parseSetStatement(c.event.Statement) {
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			// 会话跟踪返回的是服务端实际生效的值，优先使用
+			if _, ok := vars[name]; !ok {
+				vars[name] = value
+			}
+		}
+	case COM_RESET_CONNECTION, COM_CHANGE_USER:
+		if session.Variables != nil {
+			session.Variables = nil
+			changed = true
+		}
+	}
+	if len(resp.Schema) != 0 {
+		stm.database = resp.Schema
+	}
+	if len(vars) != 0 {
+		merged := make(map[string]string, len(session.Variables)+len(vars))
+		for name, value := range session.Variables {
+			merged[name] = value
+		}
+		for name, value := range vars {
+			merged[name] = value
+		}
+		session.Variables = merged
+		changed = true
+	}
+
+	if changed {
+		stm.session = &session
+	}
+}
+
+// emit 设置了慢查询阈值时只输出超过阈值的语句。
+// 没有响应的命令(COM_QUIT、COM_STMT_CLOSE 或没有抓到响应)无法得到耗时，总是输出
+func (stm *Stream) emit(e *event.Event) {
+	if stm.slow > 0 && len(e.Result) != 0 && e.Latency < stm.slow && len(e.Alert) == 0 {
+		return
+	}
+	stm.sink.Emit(e)
+}
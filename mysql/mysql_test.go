@@ -0,0 +1,70 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestStream(sink *sliceSink, slow time.Duration) *Stream {
+	return &Stream{ID: "s", logger: logrus.New(), sink: sink, slow: slow, clientAddr: "10.0.0.2:5000", serverAddr: "10.0.0.1:3306",
+		StmtMap: map[uint32]*Statement{}, activities: newActivities()}
+}
+
+func TestLatency(t *testing.T) {
+	eof := []byte{iEOF, 0, 0, 2, 0}
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, c := range []struct {
+		name    string
+		resp    [][]byte
+		latency time.Duration
+	}{
+		{"ok", [][]byte{okPacket(SERVER_STATUS_AUTOCOMMIT)}, time.Millisecond},
+		// 从请求的第一个包到响应的最后一个包
+		{"resultset", [][]byte{{1}, columnPacket("id", MYSQL_TYPE_LONG), eof, {1, '1'}, eof}, 5 * time.Millisecond},
+		{"no response", nil, 0},
+	} {
+		var sink sliceSink
+		stm := newTestStream(&sink, 0)
+		query := append([]byte{COM_QUERY}, "SELECT id FROM t"...)
+		stm.resolveClientPacket(&Packet{IsClientFlow: true, Payload: query, Length: len(query), Timestamp: t0})
+		for i, resp := range c.resp {
+			stm.resolveServerPacket(&Packet{Seq: uint8(i + 1), Payload: resp, Length: len(resp), Timestamp: t0.Add(time.Duration(i+1) * time.Millisecond)})
+		}
+		stm.finish()
+		if len(sink) != 1 || sink[0].Latency != c.latency {
+			t.Errorf("%s: unexpected events: %+v", c.name, sink)
+		}
+	}
+}
+
+func TestSlow(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	slow := 100 * time.Millisecond
+	for _, c := range []struct {
+		name    string
+		payload []byte
+		latency time.Duration // 负数表示没有响应
+		emitted bool
+	}{
+		{"below", append([]byte{COM_QUERY}, "SELECT 1"...), slow - time.Millisecond, false},
+		{"equal", append([]byte{COM_QUERY}, "SELECT 1"...), slow, true},
+		{"above", append([]byte{COM_QUERY}, "SELECT 1"...), slow + time.Millisecond, true},
+		{"no response", append([]byte{COM_QUERY}, "SELECT 1"...), -1, true},
+		{"quit", []byte{COM_QUIT}, -1, true},
+		{"stmt close", []byte{COM_STMT_CLOSE, 1, 0, 0, 0}, -1, true},
+	} {
+		var sink sliceSink
+		stm := newTestStream(&sink, slow)
+		stm.resolveClientPacket(&Packet{IsClientFlow: true, Payload: c.payload, Length: len(c.payload), Timestamp: t0})
+		if c.latency >= 0 {
+			ok := okPacket(SERVER_STATUS_AUTOCOMMIT)
+			stm.resolveServerPacket(&Packet{Seq: 1, Payload: ok, Length: len(ok), Timestamp: t0.Add(c.latency)})
+		}
+		stm.finish()
+		if emitted := len(sink) == 1; emitted != c.emitted {
+			t.Errorf("%s: emitted = %v, want %v", c.name, emitted, c.emitted)
+		}
+	}
+}
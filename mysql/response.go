@@ -0,0 +1,424 @@
+package mysql
+
+// OKPacket 服务端 OK 包，结果集结束时也可能以 0xfe 开头
+type OKPacket struct {
+	AffectedRows uint64
+	LastInsertID uint64
+	Status       uint16
+	Warnings     uint16
+	Info         string
+	Schema       string            // SESSION_TRACK_SCHEMA
+	Variables    map[string]string // SESSION_TRACK_SYSTEM_VARIABLES
+}
+
+// ErrPacket 服务端 ERR 包
+type ErrPacket struct {
+	Code     uint16
+	SQLState string
+	Message  string
+}
+
+// ColumnDefinition 结果集或预编译语句的列定义 (Protocol::ColumnDefinition41)
+type ColumnDefinition struct {
+	Schema   string
+	Table    string
+	OrgTable string
+	Name     string
+	OrgName  string
+	Charset  uint16
+	Length   uint32
+	Type     byte
+	Flags    uint16
+	Decimals byte
+}
+
+// PrepareOK COM_STMT_PREPARE 成功时的第一个响应包
+type PrepareOK struct {
+	StmtID     uint32
+	NumColumns uint16
+	NumParams  uint16
+	Warnings   uint16
+}
+
+// parseOKPacket sessionTrack 为连接是否协商了 CLIENT_SESSION_TRACK
+func parseOKPacket(data []byte, sessionTrack bool) (*OKPacket, error) {
+	r := newFieldReader(data)
+	r.Skip(1)
+	ok := &OKPacket{}
+	ok.AffectedRows, _ = r.LengthEncodedInt()
+	ok.LastInsertID, _ = r.LengthEncodedInt()
+	ok.Status = r.Uint16()
+	ok.Warnings = r.Uint16()
+	if !sessionTrack && ok.Status&SERVER_SESSION_STATE_CHANGED == 0 {
+		ok.Info = string(r.Rest())
+		return ok, r.err
+	}
+
+	if r.Len() > 0 {
+		info, _ := r.LengthEncodedString()
+		ok.Info = string(info)
+	}
+	if ok.Status&SERVER_SESSION_STATE_CHANGED != 0 && r.Len() > 0 {
+		state, _ := r.LengthEncodedString()
+		ok.Schema, ok.Variables = parseSessionState(state)
+	}
+	return ok, r.err
+}
+
+func parseErrPacket(data []byte) (*ErrPacket, error) {
+	r := newFieldReader(data)
+	r.Skip(1)
+	e := &ErrPacket{Code: r.Uint16()}
+	if r.Len() > 0 && r.data[r.pos] == '#' {
+		r.Skip(1)
+		e.SQLState = string(r.Bytes(5))
+	}
+	e.Message = string(r.Rest())
+	return e, r.err
+}
+
+// parseEOFPacket 返回 EOF 包中的 warnings 和状态标志
+func parseEOFPacket(data []byte) (warnings, status uint16) {
+	r := newFieldReader(data)
+	r.Skip(1)
+	warnings = r.Uint16()
+	status = r.Uint16()
+	return
+}
+
+func parseColumnDefinition(data []byte) (*ColumnDefinition, error) {
+	r := newFieldReader(data)
+	col := &ColumnDefinition{}
+	r.LengthEncodedString() // catalog，固定为 def
+	schema, _ := r.LengthEncodedString()
+	table, _ := r.LengthEncodedString()
+	orgTable, _ := r.LengthEncodedString()
+	name, _ := r.LengthEncodedString()
+	orgName, _ := r.LengthEncodedString()
+	r.LengthEncodedInt() // 固定长度字段的长度，0x0c
+	col.Schema = string(schema)
+	col.Table = string(table)
+	col.OrgTable = string(orgTable)
+	col.Name = string(name)
+	col.OrgName = string(orgName)
+	col.Charset = r.Uint16()
+	col.Length = r.Uint32()
+	col.Type = r.Byte()
+	col.Flags = r.Uint16()
+	col.Decimals = r.Byte()
+	return col, r.err
+}
+
+func parsePrepareOK(data []byte) (*PrepareOK, error) {
+	r := newFieldReader(data)
+	r.Skip(1)
+	p := &PrepareOK{
+		StmtID:     r.Uint32(),
+		NumColumns: r.Uint16(),
+		NumParams:  r.Uint16(),
+	}
+	r.Skip(1) // reserved
+	if r.Len() >= 2 {
+		p.Warnings = r.Uint16()
+	}
+	return p, r.err
+}
+
+// isEOFPacket 只有 5 字节的 EOF 包，用来和 DEPRECATE_EOF 下以 0xfe 开头的 OK 包区分
+func isEOFPacket(data []byte) bool {
+	return len(data) == 5 && data[0] == iEOF
+}
+
+// 响应解析阶段
+const (
+	phaseFirst      = iota // 第一个包: OK / ERR / LOCAL INFILE / 列数量
+	phaseColumns           // 结果集列定义
+	phaseRows              // 结果集行数据
+	phaseParamDefs         // 预编译语句参数定义
+	phaseColumnDefs        // 预编译语句列定义
+	phaseFieldList         // COM_FIELD_LIST 的列定义，以 EOF 结束
+	phaseDone
+)
+
+// Response 服务端对一条命令的完整响应，按包顺序逐个喂入
+type Response struct {
+	OK        *OKPacket
+	Err       *ErrPacket
+	Prepare   *PrepareOK
+	Columns   []*ColumnDefinition
+	Rows      int64
+	Warnings  uint16
+	Status    uint16
+	ResultSet bool
+	Packets   int
+	Bytes     int
+	HasStatus bool              // 收到过带状态标志的 OK/EOF 包
+	Schema    string            // 会话跟踪中切换到的库
+	Variables map[string]string // 会话跟踪中修改的变量
+	RowData   [][]any           // 保留的行数据，多结果集时依次追加
+
+	SessionTrack bool // 连接协商了 CLIENT_SESSION_TRACK
+	CaptureRows  int  // 最多保留的行数，0 表示不保留
+	MaxRowBytes  int  // 每行保留的文本和二进制值的字节数，0 表示不限制
+
+	cmd       byte
+	phase     int
+	remaining uint64 // 当前阶段剩余的定义包数量
+	skipEOF   bool   // 定义包之后可能跟一个 EOF 包
+	columns   uint16 // 预编译语句的列数量
+	length    int    // 当前包的原始长度
+	binary    bool   // 行数据为二进制协议
+	colStart  int    // 当前结果集的列定义在 Columns 中的起始位置
+}
+
+func newResponse(cmd byte) *Response {
+	r := &Response{cmd: cmd, binary: cmd == COM_STMT_EXECUTE || cmd == COM_STMT_FETCH}
+	switch cmd {
+	case COM_FIELD_LIST:
+		r.phase = phaseFieldList
+	case COM_STMT_FETCH:
+		r.phase = phaseRows
+	}
+	return r
+}
+
+// Done 响应是否已经完整
+func (r *Response) Done() bool {
+	return r.phase == phaseDone
+}
+
+// isTerminator 结果集结束包: EOF 或 0xfe 开头的 OK 包，行数据以 0xfe 开头时长度至少为 0xffffff。
+// 负载可能被截断，所以按包的原始长度判断
+func (r *Response) isTerminator(data []byte) bool {
+	return len(data) > 0 && data[0] == iEOF && r.length < maxPayloadLength
+}
+
+// Feed 处理一个服务端包，length 为包的原始长度 (负载可能被截断)，返回响应是否已经完整
+func (r *Response) Feed(data []byte, length int) (bool, error) {
+	if r.Done() || len(data) == 0 {
+		return r.Done(), nil
+	}
+	r.Packets++
+	r.Bytes += wireSize(length)
+	r.length = length
+
+	var err error
+	switch r.phase {
+	case phaseFirst:
+		err = r.feedFirst(data)
+	case phaseColumns:
+		var col *ColumnDefinition
+		if col, err = parseColumnDefinition(data); err == nil {
+			r.Columns = append(r.Columns, col)
+		}
+		if r.remaining--; r.remaining == 0 {
+			r.phase = phaseRows
+			r.skipEOF = true
+		}
+	case phaseRows:
+		err = r.feedRow(data)
+	case phaseParamDefs, phaseColumnDefs:
+		err = r.feedDefinition(data)
+	case phaseFieldList:
+		switch {
+		case data[0] == iERR:
+			r.Err, err = parseErrPacket(data)
+			r.phase = phaseDone
+		case r.isTerminator(data):
+			r.setStatus(parseEOFPacket(data))
+			r.phase = phaseDone
+		default:
+			var col *ColumnDefinition
+			if col, err = parseColumnDefinition(data); err == nil {
+				r.Columns = append(r.Columns, col)
+			}
+		}
+	}
+	return r.Done(), err
+}
+
+func (r *Response) feedFirst(data []byte) error {
+	var err error
+	switch {
+	case data[0] == iERR:
+		r.Err, err = parseErrPacket(data)
+		r.phase = phaseDone
+	case (r.cmd == COM_CONNECT || r.cmd == COM_CHANGE_USER) && data[0] != iOK:
+		// 认证切换 (0xfe) 或 AuthMoreData (0x01)，等待最终的 OK/ERR
+	case r.cmd == COM_STATISTICS:
+		// 响应是一个字符串
+		r.phase = phaseDone
+	case data[0] == iOK && r.cmd == COM_STMT_PREPARE:
+		if r.Prepare, err = parsePrepareOK(data); err != nil {
+			r.phase = phaseDone
+			return err
+		}
+		r.columns = r.Prepare.NumColumns
+		r.Warnings = r.Prepare.Warnings
+		r.remaining = uint64(r.Prepare.NumParams)
+		r.phase = phaseParamDefs
+		if r.remaining == 0 {
+			r.startColumnDefs()
+		}
+	case data[0] == iOK:
+		r.setOK(data)
+	case data[0] == iLocalInFile:
+		// LOAD DATA LOCAL INFILE: 客户端发送文件后服务端再返回 OK/ERR
+	case data[0] == iEOF:
+		// 认证切换等，等待最终的 OK/ERR
+		if isEOFPacket(data) {
+			r.setStatus(parseEOFPacket(data))
+			r.phase = phaseDone
+		}
+	default:
+		n, _ := newFieldReader(data).LengthEncodedInt()
+		if n == 0 {
+			r.phase = phaseDone
+			return ErrMalformPacket
+		}
+		r.ResultSet = true
+		r.remaining = n
+		r.colStart = len(r.Columns)
+		r.phase = phaseColumns
+	}
+	return err
+}
+
+func (r *Response) feedRow(data []byte) error {
+	if r.skipEOF && isEOFPacket(data) {
+		r.skipEOF = false
+		r.setStatus(parseEOFPacket(data))
+		// 打开了游标，行数据通过 COM_STMT_FETCH 获取
+		if r.Status&SERVER_STATUS_CURSOR_EXISTS != 0 {
+			r.phase = phaseDone
+		}
+		return nil
+	}
+	r.skipEOF = false
+
+	switch {
+	case data[0] == iERR:
+		var err error
+		r.Err, err = parseErrPacket(data)
+		r.phase = phaseDone
+		return err
+	case r.isTerminator(data):
+		if isEOFPacket(data) {
+			r.setStatus(parseEOFPacket(data))
+		} else if ok, err := parseOKPacket(data, r.SessionTrack); err == nil {
+			r.setStatus(ok.Warnings, ok.Status)
+			r.trackSession(ok)
+		}
+		r.nextResult()
+	default:
+		r.Rows++
+		if len(r.RowData) < r.CaptureRows {
+			return r.captureRow(data)
+		}
+	}
+	return nil
+}
+
+// captureRow 按当前结果集的列定义解析一行，负载被截断时不保留
+func (r *Response) captureRow(data []byte) error {
+	cols := r.Columns[r.colStart:]
+	if len(cols) == 0 || len(data) < r.length {
+		return nil
+	}
+	var (
+		row []any
+		err error
+	)
+	if r.binary {
+		row, err = decodeBinaryRow(data, cols, r.MaxRowBytes)
+	} else {
+		row, err = decodeTextRow(data, cols, r.MaxRowBytes)
+	}
+	if err != nil {
+		return err
+	}
+	r.RowData = append(r.RowData, row)
+	return nil
+}
+
+func (r *Response) feedDefinition(data []byte) error {
+	if r.skipEOF && isEOFPacket(data) {
+		r.skipEOF = false
+		return nil
+	}
+	r.skipEOF = false
+
+	col, err := parseColumnDefinition(data)
+	if err == nil && r.phase == phaseColumnDefs {
+		r.Columns = append(r.Columns, col)
+	}
+	if r.remaining > 0 {
+		r.remaining--
+	}
+	if r.remaining == 0 {
+		if r.phase == phaseParamDefs {
+			r.startColumnDefs()
+			r.skipEOF = r.phase != phaseDone
+		} else {
+			r.phase = phaseDone
+		}
+	}
+	return err
+}
+
+func (r *Response) startColumnDefs() {
+	r.remaining = uint64(r.columns)
+	r.phase = phaseColumnDefs
+	if r.remaining == 0 {
+		// 定义之后可能还有一个 EOF 包，没有等待的命令时会被忽略
+		r.phase = phaseDone
+	}
+}
+
+func (r *Response) setOK(data []byte) {
+	ok, err := parseOKPacket(data, r.SessionTrack)
+	if err != nil {
+		r.phase = phaseDone
+		return
+	}
+	if r.OK == nil {
+		r.OK = ok
+	} else {
+		// 多语句时累计影响行数
+		r.OK.AffectedRows += ok.AffectedRows
+		r.OK.LastInsertID = ok.LastInsertID
+		r.OK.Status = ok.Status
+		r.OK.Warnings += ok.Warnings
+		r.OK.Info = ok.Info
+	}
+	r.setStatus(ok.Warnings, ok.Status)
+	r.trackSession(ok)
+	r.nextResult()
+}
+
+func (r *Response) setStatus(warnings, status uint16) {
+	r.Warnings, r.Status = warnings, status
+	r.HasStatus = true
+}
+
+// trackSession 合并 OK 包中的会话跟踪信息
+func (r *Response) trackSession(ok *OKPacket) {
+	if len(ok.Schema) != 0 {
+		r.Schema = ok.Schema
+	}
+	for name, value := range ok.Variables {
+		if r.Variables == nil {
+			r.Variables = make(map[string]string)
+		}
+		r.Variables[name] = value
+	}
+}
+
+// nextResult 还有后续结果集时回到第一阶段
+func (r *Response) nextResult() {
+	if r.Status&SERVER_MORE_RESULTS_EXISTS != 0 {
+		r.phase = phaseFirst
+		return
+	}
+	r.phase = phaseDone
+}
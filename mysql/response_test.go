@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+)
+
+func lenencStr(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func columnPacket(name string, tp byte) []byte {
+	var b []byte
+	for _, s := range []string{"def", "db", "t", "t", name, name} {
+		b = append(b, lenencStr(s)...)
+	}
+	b = append(b, 0x0c, 33, 0, 11, 0, 0, 0, tp, 0, 0, 0, 0, 0)
+	return b
+}
+
+func feedAll(t *testing.T, r *Response, packets ...[]byte) {
+	t.Helper()
+	for i, p := range packets {
+		done, err := r.Feed(p, len(p))
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if done != (i == len(packets)-1) {
+			t.Fatalf("packet %d: done=%v", i, done)
+		}
+	}
+}
+
+func TestResponseResultSet(t *testing.T) {
+	eof := []byte{iEOF, 0, 0, 2, 0}
+	r := newResponse(COM_QUERY)
+	feedAll(t, r,
+		[]byte{2},
+		columnPacket("id", MYSQL_TYPE_LONG),
+		columnPacket("name", MYSQL_TYPE_VAR_STRING),
+		eof,
+		[]byte{1, '1', 3, 'a', 'b', 'c'},
+		[]byte{1, '2', 3, 'd', 'e', 'f'},
+		eof,
+	)
+	if !r.ResultSet || r.Rows != 2 || len(r.Columns) != 2 || r.Columns[1].Name != "name" {
+		t.Fatalf("unexpected response: %+v", r)
+	}
+}
+
+func TestResponseMultiResults(t *testing.T) {
+	r := newResponse(COM_QUERY)
+	more := make([]byte, 2)
+	binary.LittleEndian.PutUint16(more, SERVER_MORE_RESULTS_EXISTS)
+	feedAll(t, r,
+		append([]byte{iOK, 1, 0}, append(more, 0, 0)...),
+		[]byte{iOK, 2, 5, 2, 0, 0, 0},
+	)
+	if r.OK.AffectedRows != 3 || r.OK.LastInsertID != 5 {
+		t.Fatalf("unexpected ok: %+v", r.OK)
+	}
+}
+
+func TestResponsePrepare(t *testing.T) {
+	ok := []byte{iOK, 7, 0, 0, 0, 1, 0, 2, 0, 0, 0, 0}
+	r := newResponse(COM_STMT_PREPARE)
+	feedAll(t, r,
+		ok,
+		columnPacket("?", MYSQL_TYPE_VAR_STRING),
+		columnPacket("?", MYSQL_TYPE_VAR_STRING),
+		[]byte{iEOF, 0, 0, 2, 0},
+		columnPacket("id", MYSQL_TYPE_LONG),
+	)
+	if r.Prepare.StmtID != 7 || r.Prepare.NumParams != 2 || len(r.Columns) != 1 {
+		t.Fatalf("unexpected prepare: %+v %+v", r.Prepare, r.Columns)
+	}
+}
+
+func TestResponseErr(t *testing.T) {
+	r := newResponse(COM_QUERY)
+	feedAll(t, r, append([]byte{iERR, 0x48, 0x04, '#'}, "42000You have an error"...))
+	if r.Err.Code != 1096 || r.Err.SQLState != "42000" || r.Err.Message != "You have an error" {
+		t.Fatalf("unexpected err: %+v", r.Err)
+	}
+}
+
+func TestResponseCaptureRows(t *testing.T) {
+	eof := []byte{iEOF, 0, 0, 2, 0}
+	r := newResponse(COM_QUERY)
+	r.CaptureRows, r.MaxRowBytes = 1, 2
+	feedAll(t, r,
+		[]byte{2},
+		columnPacket("id", MYSQL_TYPE_LONG),
+		columnPacket("name", MYSQL_TYPE_VAR_STRING),
+		eof,
+		[]byte{1, '1', 3, 'a', 'b', 'c'},
+		[]byte{0xfb, 3, 'd', 'e', 'f'},
+		eof,
+	)
+	if len(r.RowData) != 1 || r.RowData[0][0] != int64(1) {
+		t.Fatalf("unexpected rows: %v", r.RowData)
+	}
+	if v, ok := r.RowData[0][1].(event.Truncated); !ok || string(v.Data) != "ab" || v.Size != 3 {
+		t.Fatalf("expected truncated value, got %v", r.RowData[0][1])
+	}
+
+	// 二进制协议: 第二列为 NULL，位图从第 2 位开始
+	r = newResponse(COM_STMT_EXECUTE)
+	r.CaptureRows = 10
+	feedAll(t, r,
+		[]byte{3},
+		columnPacket("id", MYSQL_TYPE_LONGLONG),
+		columnPacket("deleted", MYSQL_TYPE_TINY),
+		columnPacket("name", MYSQL_TYPE_VAR_STRING),
+		eof,
+		[]byte{iOK, 1 << 3, 7, 0, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'},
+		eof,
+	)
+	if len(r.RowData) != 1 {
+		t.Fatalf("unexpected rows: %v", r.RowData)
+	}
+	if row := r.RowData[0]; row[0] != int64(7) || row[1] != nil || row[2] != "abc" {
+		t.Fatalf("unexpected binary row: %#v", row)
+	}
+}
@@ -0,0 +1,132 @@
+package mysql
+
+import (
+	"strconv"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+)
+
+// 列定义中的标志
+const (
+	UNSIGNED_FLAG uint16 = 0x0020
+	BINARY_FLAG   uint16 = 0x0080
+)
+
+// binaryCharset 二进制列 (BLOB、BINARY、VARBINARY) 的字符集编号
+const binaryCharset = 63
+
+// decodeTextRow 解析文本协议的行: 每列一个长度编码字符串，0xfb 为 NULL。
+// 文本和二进制值共用 limit 字节，超出部分标记为截断，limit 为 0 时不限制
+func decodeTextRow(data []byte, cols []*ColumnDefinition, limit int) ([]any, error) {
+	r := newFieldReader(data)
+	budget := newRowBudget(limit)
+	row := make([]any, len(cols))
+	for i, col := range cols {
+		v, isNull := r.LengthEncodedString()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if !isNull {
+			row[i] = budget.limit(textValue(col, v))
+		}
+	}
+	return row, nil
+}
+
+// decodeBinaryRow 解析二进制协议的行: 0x00 + NULL 位图 (前 2 位保留) + 非 NULL 列的值
+func decodeBinaryRow(data []byte, cols []*ColumnDefinition, limit int) ([]any, error) {
+	bitmapLen := (len(cols) + 7 + 2) >> 3
+	if len(data) < 1+bitmapLen || data[0] != iOK {
+		return nil, ErrMalformPacket
+	}
+	nullBitmap := data[1 : 1+bitmapLen]
+	pos := 1 + bitmapLen
+
+	budget := newRowBudget(limit)
+	row := make([]any, len(cols))
+	for i, col := range cols {
+		bit := i + 2
+		if nullBitmap[bit>>3]&(1<<(uint(bit)%8)) > 0 {
+			continue
+		}
+		v, n, err := readBinaryValue(col.Type, col.Flags&UNSIGNED_FLAG != 0, data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		if b, ok := v.([]byte); ok && !isBinaryColumn(col) {
+			v = string(b)
+		}
+		row[i] = budget.limit(v)
+	}
+	return row, nil
+}
+
+// textValue 按列类型转换文本协议的值: 整数和浮点数转换为数值，二进制列保留字节，其余为字符串
+func textValue(col *ColumnDefinition, v []byte) any {
+	switch col.Type {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24, MYSQL_TYPE_LONG,
+		MYSQL_TYPE_LONGLONG, MYSQL_TYPE_YEAR:
+		if col.Flags&UNSIGNED_FLAG != 0 {
+			if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+				return n
+			}
+		} else if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+	case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return f
+		}
+	}
+	if isBinaryColumn(col) {
+		return v
+	}
+	// DECIMAL 保留字符串避免丢失精度，日期时间保持服务端的格式
+	return string(v)
+}
+
+// isBinaryColumn BIT、GEOMETRY 和二进制字符集的字符串列按字节输出
+func isBinaryColumn(col *ColumnDefinition) bool {
+	switch col.Type {
+	case MYSQL_TYPE_BIT, MYSQL_TYPE_GEOMETRY:
+		return true
+	case MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
+		MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_VARCHAR:
+		return col.Charset == binaryCharset
+	}
+	return false
+}
+
+// rowBudget 一行中文本和二进制值剩余可保留的字节数
+type rowBudget struct {
+	unlimited bool
+	remaining int
+}
+
+func newRowBudget(limit int) *rowBudget {
+	return &rowBudget{unlimited: limit <= 0, remaining: limit}
+}
+
+// limit 超出剩余字节数的值截断为 event.Truncated
+func (b *rowBudget) limit(v any) any {
+	if b.unlimited {
+		return v
+	}
+	var data []byte
+	switch s := v.(type) {
+	case string:
+		data = []byte(s)
+	case []byte:
+		data = s
+	default:
+		return v
+	}
+	if len(data) <= b.remaining {
+		b.remaining -= len(data)
+		return v
+	}
+	keep := data[:b.remaining]
+	b.remaining = 0
+	return event.Truncated{Data: keep, Size: len(data)}
+}
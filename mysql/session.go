@@ -0,0 +1,166 @@
+package mysql
+
+import (
+	"strings"
+)
+
+// 会话跟踪信息的类型 (OK 包中的 session_state_info)
+const (
+	SESSION_TRACK_SYSTEM_VARIABLES            byte = 0x00
+	SESSION_TRACK_SCHEMA                      byte = 0x01
+	SESSION_TRACK_STATE_CHANGE                byte = 0x02
+	SESSION_TRACK_GTIDS                       byte = 0x03
+	SESSION_TRACK_TRANSACTION_CHARACTERISTICS byte = 0x04
+	SESSION_TRACK_TRANSACTION_STATE           byte = 0x05
+)
+
+// parseSessionState 解析会话跟踪信息，返回切换到的库和修改的系统变量
+func parseSessionState(data []byte) (schema string, vars map[string]string) {
+	r := newFieldReader(data)
+	for r.Len() > 0 {
+		tp := r.Byte()
+		entry, _ := r.LengthEncodedString()
+		if r.err != nil {
+			break
+		}
+		er := newFieldReader(entry)
+		switch tp {
+		case SESSION_TRACK_SCHEMA:
+			name, _ := er.LengthEncodedString()
+			schema = string(name)
+		case SESSION_TRACK_SYSTEM_VARIABLES:
+			name, _ := er.LengthEncodedString()
+			value, _ := er.LengthEncodedString()
+			if er.err == nil {
+				if vars == nil {
+					vars = make(map[string]string)
+				}
+				vars[strings.ToLower(string(name))] = string(value)
+			}
+		}
+	}
+	return
+}
+
+// parseUseStatement 解析 USE db 语句
+func parseUseStatement(sql string) (string, bool) {
+	sql = strings.TrimRight(strings.TrimSpace(sql), "; \t\r\n")
+	if len(sql) < 4 || !strings.EqualFold(sql[:3], "use") || !isSpace(sql[3]) {
+		return "", false
+	}
+	db := strings.TrimSpace(sql[4:])
+	return unquoteIdentifier(db), len(db) != 0
+}
+
+// parseSetStatement 解析 SET 语句中的会话变量和用户变量，忽略 GLOBAL/PERSIST 变量和 SET TRANSACTION
+func parseSetStatement(sql string) map[string]string {
+	sql = strings.TrimRight(strings.TrimSpace(sql), "; \t\r\n")
+	if len(sql) < 4 || !strings.EqualFold(sql[:3], "set") || !isSpace(sql[3]) {
+		return nil
+	}
+	body := strings.TrimSpace(sql[4:])
+
+	vars := make(map[string]string)
+	switch lower := strings.ToLower(body); {
+	case strings.HasPrefix(lower, "names "):
+		charset, _, _ := strings.Cut(strings.TrimSpace(body[6:]), " ")
+		vars["names"] = unquoteValue(charset)
+		return vars
+	case strings.HasPrefix(lower, "character set "), strings.HasPrefix(lower, "charset "):
+		_, charset, _ := strings.Cut(lower, "set ")
+		vars["character_set"] = unquoteValue(strings.TrimSpace(charset))
+		return vars
+	case strings.HasPrefix(lower, "transaction "):
+		return nil
+	}
+
+	for _, assign := range splitTopLevel(body, ',') {
+		name, value, ok := cutAssign(assign)
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		lower := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(lower, "global "), strings.HasPrefix(lower, "persist "),
+			strings.HasPrefix(lower, "persist_only "), strings.HasPrefix(lower, "@@global."),
+			strings.HasPrefix(lower, "@@persist."), strings.HasPrefix(lower, "@@persist_only."):
+			continue
+		case strings.HasPrefix(lower, "session "), strings.HasPrefix(lower, "local "):
+			_, name, _ = strings.Cut(name, " ")
+		case strings.HasPrefix(lower, "@@session."), strings.HasPrefix(lower, "@@local."):
+			_, name, _ = strings.Cut(name, ".")
+		case strings.HasPrefix(lower, "@@"):
+			name = name[2:]
+		}
+		name = strings.TrimSpace(name)
+		if !strings.HasPrefix(name, "@") {
+			// 系统变量名不区分大小写
+			name = strings.ToLower(unquoteIdentifier(name))
+		}
+		vars[name] = unquoteValue(strings.TrimSpace(value))
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	return vars
+}
+
+// cutAssign 按 = 或 := 拆分赋值
+func cutAssign(s string) (name, value string, ok bool) {
+	idx := strings.IndexByte(s, '=')
+	if idx <= 0 {
+		return "", "", false
+	}
+	name = strings.TrimSuffix(s[:idx], ":")
+	return name, s[idx+1:], true
+}
+
+// splitTopLevel 按分隔符拆分，忽略引号和括号内的分隔符
+func splitTopLevel(s string, sep byte) []string {
+	var (
+		parts []string
+		depth int
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && s[0] == '`' && s[len(s)-1] == '`' {
+		return strings.ReplaceAll(s[1:len(s)-1], "``", "`")
+	}
+	return s
+}
+
+func unquoteValue(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
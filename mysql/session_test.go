@@ -0,0 +1,38 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSetStatement(t *testing.T) {
+	cases := map[string]map[string]string{
+		"SET autocommit=0": {"autocommit": "0"},
+		"set @@session.sql_mode = 'STRICT_ALL_TABLES'":   {"sql_mode": "STRICT_ALL_TABLES"},
+		"SET SESSION wait_timeout=60, @uid := 'a,b';":    {"wait_timeout": "60", "@uid": "a,b"},
+		"SET NAMES utf8mb4 COLLATE utf8mb4_general_ci":   {"names": "utf8mb4"},
+		"SET GLOBAL max_connections=1000":                nil,
+		"SET TRANSACTION ISOLATION LEVEL READ COMMITTED": nil,
+		"SELECT 1": nil,
+	}
+	for sql, want := range cases {
+		if got := parseSetStatement(sql); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", sql, got, want)
+		}
+	}
+
+	if db, ok := parseUseStatement("use `shop`;"); !ok || db != "shop" {
+		t.Errorf("parseUseStatement: got %q %v", db, ok)
+	}
+}
+
+func TestParseSessionState(t *testing.T) {
+	state := []byte{SESSION_TRACK_SCHEMA, 5, 4, 's', 'h', 'o', 'p'}
+	state = append(state, SESSION_TRACK_SYSTEM_VARIABLES, 14, 10)
+	state = append(state, "autocommit"...)
+	state = append(state, 2, 'O', 'N')
+	schema, vars := parseSessionState(state)
+	if schema != "shop" || vars["autocommit"] != "ON" {
+		t.Fatalf("got %q %v", schema, vars)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"strings"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
 )
 
 type Statement struct {
@@ -11,20 +15,61 @@ type Statement struct {
 	SQL        string
 	FieldCount uint16
 	ParamCount uint16
+	ParamTypes []byte // 最近一次绑定的参数类型，new-params-bound-flag 为 0 时使用
 	Args       []any
+	Columns    []*ColumnDefinition // prepare 响应中的列定义，用于解析游标返回的行
+
+	longData map[uint16]*longData // COM_STMT_SEND_LONG_DATA 发送的参数，执行或重置后清空
+}
+
+// longData 一个参数累计的 long data，超过上限的部分只记录长度
+type longData struct {
+	data []byte
+	size int
+}
+
+// AppendLongData 累计参数 idx 的 long data，最多保留 limit 字节，limit 为 0 时不限制
+func (stmt *Statement) AppendLongData(idx uint16, data []byte, limit int) {
+	if stmt.longData == nil {
+		stmt.longData = make(map[uint16]*longData)
+	}
+	ld, ok := stmt.longData[idx]
+	if !ok {
+		ld = &longData{}
+		stmt.longData[idx] = ld
+	}
+	ld.size += len(data)
+	if limit > 0 && len(ld.data)+len(data) > limit {
+		data = data[:max(limit-len(ld.data), 0)]
+	}
+	ld.data = append(ld.data, data...)
 }
 
-func (stmt *Statement) BindStmtArgs(nullBitmap, paramTypes, paramValues []byte) (err error) {
+// ResetLongData 清空已累计的 long data
+func (stmt *Statement) ResetLongData() {
+	stmt.longData = nil
+}
+
+func (ld *longData) value() any {
+	if ld.size > len(ld.data) {
+		return event.Truncated{Data: ld.data, Size: ld.size}
+	}
+	return ld.data
+}
+
+func (stmt *Statement) BindStmtArgs(nullBitmap, paramTypes, paramValues []byte) error {
+	_, err := stmt.bindArgs(nullBitmap, paramTypes, paramValues)
+	return err
+}
+
+// bindArgs 解析参数值，返回读取的字节数
+func (stmt *Statement) bindArgs(nullBitmap, paramTypes, paramValues []byte) (pos int, err error) {
 	if len(paramTypes)/2 != int(stmt.ParamCount) {
 		err = ErrMalformPacket
 		return
 	}
 
-	pos := 0
-
-	var v []byte
 	var n int
-	var isNull bool
 
 	for i := 0; i < int(stmt.ParamCount); i++ {
 		if nullBitmap[i>>3]&(1<<(uint(i)%8)) > 0 {
@@ -32,118 +77,206 @@ func (stmt *Statement) BindStmtArgs(nullBitmap, paramTypes, paramValues []byte)
 			continue
 		}
 
+		// 通过 long data 发送的参数不在参数值中
+		if ld, ok := stmt.longData[uint16(i)]; ok {
+			stmt.Args[i] = ld.value()
+			continue
+		}
+
 		tp := paramTypes[i<<1]
 		isUnsigned := (paramTypes[(i<<1)+1] & PARAM_UNSIGNED) > 0
+		if stmt.Args[i], n, err = readBinaryValue(tp, isUnsigned, paramValues[pos:]); err != nil {
+			return
+		}
+		pos += n
+	}
+	return
+}
 
-		switch tp {
-		case MYSQL_TYPE_NULL:
-			stmt.Args[i] = nil
-			continue
+// readBinaryValue 解析二进制协议中一个类型为 tp 的值，返回值和读取的字节数，
+// 执行参数和二进制结果集的行使用相同的编码
+func readBinaryValue(tp byte, isUnsigned bool, b []byte) (any, int, error) {
+	switch tp {
+	case MYSQL_TYPE_NULL:
+		return nil, 0, nil
 
-		case MYSQL_TYPE_TINY:
-			if len(paramValues) < (pos + 1) {
-				err = ErrMalformPacket
-				return
-			}
+	case MYSQL_TYPE_TINY:
+		if len(b) < 1 {
+			return nil, 0, ErrMalformPacket
+		}
+		if isUnsigned {
+			return b[0], 1, nil
+		}
+		return int8(b[0]), 1, nil
 
-			if isUnsigned {
-				stmt.Args[i] = paramValues[pos]
-			} else {
-				stmt.Args[i] = int8(paramValues[pos])
-			}
+	case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+		if len(b) < 2 {
+			return nil, 0, ErrMalformPacket
+		}
+		if isUnsigned {
+			return binary.LittleEndian.Uint16(b), 2, nil
+		}
+		return int16(binary.LittleEndian.Uint16(b)), 2, nil
 
-			pos++
-			continue
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG:
+		if len(b) < 4 {
+			return nil, 0, ErrMalformPacket
+		}
+		if isUnsigned {
+			return binary.LittleEndian.Uint32(b), 4, nil
+		}
+		return int32(binary.LittleEndian.Uint32(b)), 4, nil
 
-		case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
-			if len(paramValues) < (pos + 2) {
-				err = ErrMalformPacket
-				return
-			}
-
-			if isUnsigned {
-				stmt.Args[i] = binary.LittleEndian.Uint16(paramValues[pos : pos+2])
-			} else {
-				stmt.Args[i] = int16(binary.LittleEndian.Uint16(paramValues[pos : pos+2]))
-			}
-			pos += 2
-			continue
+	case MYSQL_TYPE_LONGLONG:
+		if len(b) < 8 {
+			return nil, 0, ErrMalformPacket
+		}
+		if isUnsigned {
+			return binary.LittleEndian.Uint64(b), 8, nil
+		}
+		return int64(binary.LittleEndian.Uint64(b)), 8, nil
 
-		case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG:
-			if len(paramValues) < (pos + 4) {
-				err = ErrMalformPacket
-				return
-			}
-
-			if isUnsigned {
-				stmt.Args[i] = binary.LittleEndian.Uint32(paramValues[pos : pos+4])
-			} else {
-				stmt.Args[i] = int32(binary.LittleEndian.Uint32(paramValues[pos : pos+4]))
-			}
-			pos += 4
-			continue
+	case MYSQL_TYPE_FLOAT:
+		if len(b) < 4 {
+			return nil, 0, ErrMalformPacket
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), 4, nil
 
-		case MYSQL_TYPE_LONGLONG:
-			if len(paramValues) < (pos + 8) {
-				err = ErrMalformPacket
-				return
-			}
-
-			if isUnsigned {
-				stmt.Args[i] = binary.LittleEndian.Uint64(paramValues[pos : pos+8])
-			} else {
-				stmt.Args[i] = int64(binary.LittleEndian.Uint64(paramValues[pos : pos+8]))
-			}
-			pos += 8
-			continue
+	case MYSQL_TYPE_DOUBLE:
+		if len(b) < 8 {
+			return nil, 0, ErrMalformPacket
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), 8, nil
 
-		case MYSQL_TYPE_FLOAT:
-			if len(paramValues) < (pos + 4) {
-				err = ErrMalformPacket
-				return
-			}
+	case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_VARCHAR,
+		MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
+		MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
+		MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON:
+		r := newFieldReader(b)
+		v, isNull := r.LengthEncodedString()
+		if r.err != nil {
+			return nil, 0, ErrMalformPacket
+		}
+		if isNull {
+			return nil, r.pos, nil
+		}
+		return v, r.pos, nil
 
-			stmt.Args[i] = math.Float32frombits(binary.LittleEndian.Uint32(paramValues[pos : pos+4]))
-			pos += 4
-			continue
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE, MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME:
+		return readBinaryDateTime(tp, b)
+
+	case MYSQL_TYPE_TIME:
+		return readBinaryTime(b)
+	}
+	return nil, 0, fmt.Errorf("STMT UNKNOWN FieldType %d", tp)
+}
 
-		case MYSQL_TYPE_DOUBLE:
-			if len(paramValues) < (pos + 8) {
-				err = ErrMalformPacket
-				return
-			}
+// readBinaryDateTime 解析二进制协议的 DATE/DATETIME/TIMESTAMP:
+// 长度(0/4/7/11) + 年(2) 月 日 [时 分 秒 [微秒(4)]]。
+// 按原始字段格式化，不经过 time.Date，0000-00-00、2024-00-15 这类零值日期原样输出
+func readBinaryDateTime(tp byte, b []byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, ErrMalformPacket
+	}
+	length := int(b[0])
+	if len(b) < 1+length {
+		return "", 0, ErrMalformPacket
+	}
+	v := b[1 : 1+length]
 
-			stmt.Args[i] = math.Float64frombits(binary.LittleEndian.Uint64(paramValues[pos : pos+8]))
-			pos += 8
+	var year, month, day, hour, min, sec, usec int
+	switch length {
+	case 0:
+	case 11:
+		usec = int(binary.LittleEndian.Uint32(v[7:11]))
+		fallthrough
+	case 7:
+		hour, min, sec = int(v[4]), int(v[5]), int(v[6])
+		fallthrough
+	case 4:
+		year = int(binary.LittleEndian.Uint16(v[0:2]))
+		month, day = int(v[2]), int(v[3])
+	default:
+		return "", 0, ErrMalformPacket
+	}
+	if tp == MYSQL_TYPE_DATE || tp == MYSQL_TYPE_NEWDATE {
+		return fmt.Sprintf("%04d-%02d-%02d", year, month, day), 1 + length, nil
+	}
+	if usec > 0 {
+		return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d.%06d", year, month, day, hour, min, sec, usec), 1 + length, nil
+	}
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, min, sec), 1 + length, nil
+}
+
+// readBinaryTime 解析二进制协议的 TIME:
+// 长度(0/8/12) + 是否为负 + 天(4) 时 分 秒 [微秒(4)]
+func readBinaryTime(b []byte) (time.Duration, int, error) {
+	if len(b) < 1 {
+		return 0, 0, ErrMalformPacket
+	}
+	length := int(b[0])
+	if len(b) < 1+length {
+		return 0, 0, ErrMalformPacket
+	}
+	v := b[1 : 1+length]
+
+	var d time.Duration
+	switch length {
+	case 0:
+		return 0, 1, nil
+	case 12:
+		d = time.Duration(binary.LittleEndian.Uint32(v[8:12])) * time.Microsecond
+		fallthrough
+	case 8:
+		d += time.Duration(binary.LittleEndian.Uint32(v[1:5]))*24*time.Hour +
+			time.Duration(v[5])*time.Hour +
+			time.Duration(v[6])*time.Minute +
+			time.Duration(v[7])*time.Second
+		if v[0] == 1 {
+			d = -d
+		}
+	default:
+		return 0, 0, ErrMalformPacket
+	}
+	return d, 1 + length, nil
+}
+
+// maxGuessParams 推断未知语句参数个数时尝试的上限
+const maxGuessParams = 1024
+
+// guessStmt 没有见到 prepare 的语句，按执行包中的类型推断参数个数。
+// params 为迭代次数之后的部分，只有带类型 (new-params-bound-flag 为 1) 时才能推断
+func guessStmt(id uint32, params []byte) *Statement {
+	if len(params) == 0 {
+		return &Statement{ID: id, SQL: fmt.Sprintf("unknown stmt %d", id)}
+	}
+
+	for n := 1; n <= maxGuessParams; n++ {
+		bitmapLen := (n + 7) >> 3
+		typesEnd := bitmapLen + 1 + n<<1
+		if len(params) < typesEnd {
+			break
+		}
+		if params[bitmapLen] != 1 || !validParamTypes(params[bitmapLen+1:typesEnd]) {
 			continue
+		}
 
-		case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_VARCHAR,
-			MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
-			MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
-			MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_GEOMETRY,
-			MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE,
-			MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIME:
-			if len(paramValues) < (pos + 1) {
-				err = ErrMalformPacket
-				return
-			}
-
-			v, isNull, n, err = LengthEncodedString(paramValues[pos:])
-			pos += n
-			if err != nil {
-				return
-			}
-
-			if !isNull {
-				stmt.Args[i] = v
-				continue
-			} else {
-				stmt.Args[i] = nil
-				continue
-			}
-		default:
-			err = fmt.Errorf("STMT UNKNOWN FieldType %d", tp)
+		stmt := &Statement{ID: id, ParamCount: uint16(n), Args: make([]any, n)}
+		pos, err := stmt.bindArgs(params[:bitmapLen], params[bitmapLen+1:typesEnd], params[typesEnd:])
+		if err != nil || pos != len(params)-typesEnd {
+			continue
 		}
+		stmt.SQL = fmt.Sprintf("unknown stmt %d (%s)", id, strings.TrimSuffix(strings.Repeat("?, ", n), ", "))
+		return stmt
 	}
-	return
+	return nil
+}
+
+func validParamTypes(types []byte) bool {
+	for i := 0; i < len(types); i += 2 {
+		if _, ok := typeNames[types[i]]; !ok || types[i+1]&^PARAM_UNSIGNED != 0 {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+)
+
+func TestBindStmtArgsTime(t *testing.T) {
+	stmt := &Statement{SQL: "SELECT ?, ?, ?", ParamCount: 3, Args: make([]any, 3)}
+	types := []byte{MYSQL_TYPE_DATETIME, 0, MYSQL_TYPE_DATE, 0, MYSQL_TYPE_TIME, 0}
+	values := []byte{
+		11, 0xe9, 0x07, 1, 2, 3, 4, 5, 0x40, 0xe2, 0x01, 0x00, // 2025-01-02 03:04:05.123456
+		4, 0xe9, 0x07, 12, 31, // 2025-12-31
+		8, 1, 1, 0, 0, 0, 2, 3, 4, // -26:03:04
+	}
+	if err := stmt.BindStmtArgs([]byte{0}, types, values); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT '2025-01-02 03:04:05.123456', '2025-12-31', '-26:03:04'"
+	if got := helper.ExplainSQL(stmt.SQL, nil, `'`, stmt.Args...); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestReadBinaryDateTimeZero(t *testing.T) {
+	for _, c := range []struct {
+		tp    byte
+		value []byte
+		want  string
+	}{
+		{MYSQL_TYPE_DATETIME, []byte{0}, "0000-00-00 00:00:00"},
+		{MYSQL_TYPE_DATE, []byte{0}, "0000-00-00"},
+		{MYSQL_TYPE_DATE, []byte{4, 0, 0, 0, 0}, "0000-00-00"},
+		{MYSQL_TYPE_DATE, []byte{4, 0xe8, 0x07, 0, 15}, "2024-00-15"},
+		{MYSQL_TYPE_DATETIME, []byte{7, 0xe8, 0x07, 2, 0, 1, 2, 3}, "2024-02-00 01:02:03"},
+	} {
+		got, n, err := readBinaryDateTime(c.tp, c.value)
+		if err != nil || n != len(c.value) || got != c.want {
+			t.Errorf("readBinaryDateTime(%v) = %s, %d, %v, want %s", c.value, got, n, err, c.want)
+		}
+	}
+}
+
+func TestBindStmtArgsLongData(t *testing.T) {
+	stmt := &Statement{SQL: "INSERT INTO t VALUES (?, ?)", ParamCount: 2, Args: make([]any, 2)}
+	stmt.AppendLongData(0, []byte("hello "), 8)
+	stmt.AppendLongData(0, []byte("world"), 8)
+	types := []byte{MYSQL_TYPE_BLOB, 0, MYSQL_TYPE_TINY, 0}
+	if err := stmt.BindStmtArgs([]byte{0}, types, []byte{7}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "INSERT INTO t VALUES ('hello wo...(truncated, 11 bytes)', 7)"
+	if got := helper.ExplainSQL(stmt.SQL, nil, `'`, stmt.Args...); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestGuessStmt(t *testing.T) {
+	params := []byte{
+		0x00, 1, // null bitmap, new-params-bound-flag
+		MYSQL_TYPE_LONGLONG, 0, MYSQL_TYPE_VAR_STRING, 0,
+		5, 0, 0, 0, 0, 0, 0, 0,
+		3, 'b', 'o', 'b',
+	}
+	stmt := guessStmt(9, params)
+	if stmt == nil {
+		t.Fatal("could not guess statement")
+	}
+
+	want := "unknown stmt 9 (5, 'bob')"
+	if got := helper.ExplainSQL(stmt.SQL, nil, `'`, stmt.Args...); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if guessStmt(9, []byte{0x00, 0}) != nil {
+		t.Fatal("guessed statement without param types")
+	}
+}
+
+// execute 构造 COM_STMT_EXECUTE，types 为 nil 时 new-params-bound-flag 为 0
+func execute(id byte, types []byte, values ...byte) []byte {
+	payload := []byte{COM_STMT_EXECUTE, id, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	if types == nil {
+		payload = append(payload, 0)
+	} else {
+		payload = append(append(payload, 1), types...)
+	}
+	return append(payload, values...)
+}
+
+func TestStmtExecute(t *testing.T) {
+	var sink sliceSink
+	stm := newTestStream(&sink, 0)
+	stm.addStmt(&Statement{ID: 1, SQL: "SELECT ?", ParamCount: 1, Args: make([]any, 1)})
+	longData := []byte{COM_STMT_SEND_LONG_DATA, 1, 0, 0, 0, 0, 0, 'x', 'y'}
+	for _, c := range []struct {
+		name    string
+		packets [][]byte
+		want    any
+	}{
+		{"new types", [][]byte{execute(1, []byte{MYSQL_TYPE_LONGLONG, 0}, 5, 0, 0, 0, 0, 0, 0, 0)}, int64(5)},
+		// new-params-bound-flag 为 0 时沿用上一次的类型
+		{"reuse types", [][]byte{execute(1, nil, 6, 0, 0, 0, 0, 0, 0, 0)}, int64(6)},
+		{"long data", [][]byte{longData, execute(1, []byte{MYSQL_TYPE_BLOB, 0})}, []byte("xy")},
+		// reset 后 long data 被丢弃，使用报文中的值
+		{"reset", [][]byte{longData, {COM_STMT_RESET, 1, 0, 0, 0}, execute(1, nil, 1, 'z')}, []byte("z")},
+	} {
+		sink = sink[:0]
+		for _, payload := range c.packets {
+			stm.resolveClientPacket(&Packet{IsClientFlow: true, Payload: payload, Length: len(payload)})
+		}
+		stm.finish()
+		e := sink[len(sink)-1]
+		if !reflect.DeepEqual(e.Args, []any{c.want}) {
+			t.Errorf("%s: args = %#v, want %#v", c.name, e.Args, c.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package mysql
+
+import (
+	"sync/atomic"
+
+	"github.com/JacksonChan-X/sql-sniffer/tlsdecrypt"
+)
+
+// secure 一条连接两个方向共享的 TLS 状态，客户端发出 SSLRequest 后两个方向都切换为 TLS
+type secure struct {
+	enabled atomic.Bool
+	conn    *tlsdecrypt.Conn
+}
+
+func newSecure() *secure {
+	return &secure{conn: tlsdecrypt.NewConn()}
+}
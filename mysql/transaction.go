@@ -0,0 +1,152 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+)
+
+// maxTxStatements 事务记录中最多保留的语句数
+const maxTxStatements = 1000
+
+// 事务结束方式
+const (
+	txCommit       = "commit"
+	txRollback     = "rollback"
+	txImplicit     = "implicit" // DDL、SET autocommit=1 等隐式提交
+	txDisconnected = "disconnected"
+)
+
+// transaction 连接上正在进行的事务
+type transaction struct {
+	first       *event.Event // 开启事务的语句
+	statements  []string
+	count       int
+	start       time.Time // 第一条语句发出的抓包时间
+	lastEnd     time.Time // 上一条语句收到响应的抓包时间
+	idle        time.Duration
+	longAlerted bool
+	idleAlerted bool
+}
+
+// trackTransaction 按响应中的 SERVER_STATUS_IN_TRANS 划分事务，
+// 该标志首次出现的语句开启事务，标志消失的语句结束事务
+func (stm *Stream) trackTransaction(c *command, resp *Response) {
+	e := c.event
+	tx := stm.tx
+	if tx == nil {
+		if !resp.HasStatus || resp.Status&SERVER_STATUS_IN_TRANS == 0 {
+			return
+		}
+		tx = &transaction{first: e, start: e.Timestamp}
+		stm.tx = tx
+	} else if !tx.lastEnd.IsZero() {
+		idle := e.Timestamp.Sub(tx.lastEnd)
+		tx.idle += idle
+		if stm.idleTx > 0 && idle >= stm.idleTx && !tx.idleAlerted {
+			tx.idleAlerted = true
+			stm.alertTransaction(event.AlertIdleTransaction, e.Timestamp, idle)
+		}
+	}
+
+	tx.count++
+	if len(tx.statements) < maxTxStatements {
+		tx.statements = append(tx.statements, e.Text())
+	}
+	tx.lastEnd = e.Timestamp.Add(e.Latency)
+
+	if stm.longTx > 0 && tx.lastEnd.Sub(tx.start) >= stm.longTx && !tx.longAlerted {
+		tx.longAlerted = true
+		stm.alertTransaction(event.AlertLongTransaction, tx.lastEnd, 0)
+	}
+
+	if resp.HasStatus && resp.Status&SERVER_STATUS_IN_TRANS == 0 {
+		stm.endTransaction(txOutcome(e.Statement))
+	}
+}
+
+// checkTransaction 连接静默时按 clock 给出的当前时间 now 检查事务是否空闲或持续过久
+func (stm *Stream) checkTransaction(now time.Time) {
+	tx := stm.tx
+	if tx == nil || stm.pending != nil || !now.After(tx.lastEnd) {
+		return
+	}
+	idle := now.Sub(tx.lastEnd)
+	if stm.idleTx > 0 && idle >= stm.idleTx && !tx.idleAlerted {
+		tx.idleAlerted = true
+		stm.alertTransaction(event.AlertIdleTransaction, now, idle)
+	}
+	if stm.longTx > 0 && now.Sub(tx.start) >= stm.longTx && !tx.longAlerted {
+		tx.longAlerted = true
+		stm.alertTransaction(event.AlertLongTransaction, now, 0)
+	}
+}
+
+// endTransaction 输出事务记录
+func (stm *Stream) endTransaction(outcome string) {
+	tx := stm.tx
+	if tx == nil {
+		return
+	}
+	stm.tx = nil
+
+	e := stm.transactionEvent(tx, tx.lastEnd)
+	e.Transaction.Outcome = outcome
+	e.Statement = fmt.Sprintf("Transaction %s, statements:%d", outcome, tx.count)
+	e.Latency = e.Transaction.Duration
+	// 回滚是正常结束，结果为 ok，由 outcome 区分提交和回滚
+	e.Result = event.ResultOK
+	if outcome == txDisconnected {
+		e.Result = event.ResultError
+	}
+	stm.sink.Emit(e)
+}
+
+func (stm *Stream) alertTransaction(alert string, ts time.Time, idle time.Duration) {
+	tx := stm.tx
+	e := stm.transactionEvent(tx, ts)
+	e.Alert = alert
+	e.Timestamp = ts
+	if alert == event.AlertIdleTransaction {
+		e.Statement = fmt.Sprintf("Transaction idle %s, statements:%d", idle, tx.count)
+	} else {
+		e.Statement = fmt.Sprintf("Transaction open %s, statements:%d", e.Transaction.Duration, tx.count)
+	}
+	stm.sink.Emit(e)
+}
+
+// transactionEvent 以开启事务的语句为模板生成事务事件，ts 为事务的结束或当前时间
+func (stm *Stream) transactionEvent(tx *transaction, ts time.Time) *event.Event {
+	first := tx.first
+	return &event.Event{
+		Protocol:   first.Protocol,
+		ClientAddr: first.ClientAddr,
+		ServerAddr: first.ServerAddr,
+		ConnID:     first.ConnID,
+		User:       first.User,
+		Database:   first.Database,
+		Conn:       first.Conn,
+		Command:    "TRANSACTION",
+		Timestamp:  tx.start,
+		Transaction: &event.Transaction{
+			Statements:     append([]string(nil), tx.statements...),
+			StatementCount: tx.count,
+			Duration:       ts.Sub(tx.start),
+			Idle:           tx.idle,
+		},
+	}
+}
+
+// txOutcome 根据结束事务的语句判断提交还是回滚
+func txOutcome(sql string) string {
+	word, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	switch strings.ToUpper(strings.TrimRight(word, ";")) {
+	case "COMMIT":
+		return txCommit
+	case "ROLLBACK":
+		return txRollback
+	}
+	return txImplicit
+}
@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/sirupsen/logrus"
+)
+
+type sliceSink []*event.Event
+
+func (s *sliceSink) Emit(e *event.Event) {
+	*s = append(*s, e)
+}
+
+func TestTrackTransaction(t *testing.T) {
+	var sink sliceSink
+	stm := &Stream{logger: logrus.New(), sink: &sink, idleTx: 2 * time.Second}
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	exec := func(sql string, at time.Duration, status uint16) {
+		e := &event.Event{Statement: sql, Timestamp: t0.Add(at), Latency: time.Millisecond}
+		stm.trackTransaction(&command{event: e}, &Response{HasStatus: true, Status: status})
+	}
+
+	exec("BEGIN", 0, SERVER_STATUS_IN_TRANS)
+	exec("UPDATE user SET name = 'jackson'", time.Second, SERVER_STATUS_IN_TRANS)
+	exec("COMMIT", 4*time.Second, SERVER_STATUS_AUTOCOMMIT)
+	exec("SELECT 1", 5*time.Second, SERVER_STATUS_AUTOCOMMIT)
+
+	if len(sink) != 2 || sink[0].Alert != event.AlertIdleTransaction {
+		t.Fatalf("unexpected events: %+v", sink)
+	}
+	tx := sink[1].Transaction
+	if tx == nil || tx.Outcome != txCommit || tx.StatementCount != 3 ||
+		tx.Duration != 4*time.Second+time.Millisecond || tx.Idle != 4*time.Second-2*time.Millisecond {
+		t.Fatalf("unexpected transaction: %+v", tx)
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	var sink sliceSink
+	clk := &clock{replay: true}
+	stm := &Stream{logger: logrus.New(), sink: &sink, clock: clk, idleTx: 2 * time.Second, longTx: 5 * time.Second}
+	t0 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	exec := func(sql string, at time.Duration, status uint16) {
+		e := &event.Event{Statement: sql, Timestamp: t0.Add(at), Latency: time.Millisecond}
+		clk.advance(e.Timestamp)
+		stm.trackTransaction(&command{event: e}, &Response{HasStatus: true, Status: status})
+	}
+
+	exec("BEGIN", 0, SERVER_STATUS_IN_TRANS)
+	// 该连接静默，其他连接的包推进抓包时间
+	clk.advance(t0.Add(time.Second))
+	stm.checkTransaction(clk.Now())
+	clk.advance(t0.Add(6 * time.Second))
+	stm.checkTransaction(clk.Now())
+	exec("ROLLBACK", 7*time.Second, SERVER_STATUS_AUTOCOMMIT)
+
+	if len(sink) != 3 || sink[0].Alert != event.AlertIdleTransaction || sink[1].Alert != event.AlertLongTransaction {
+		t.Fatalf("unexpected events: %+v", sink)
+	}
+	if !sink[0].Timestamp.Equal(t0.Add(6*time.Second)) || sink[0].Transaction.Duration != 6*time.Second {
+		t.Fatalf("unexpected idle alert: %v %+v", sink[0].Timestamp, sink[0].Transaction)
+	}
+	if e := sink[2]; e.Result != event.ResultOK || e.Transaction.Outcome != txRollback {
+		t.Fatalf("unexpected rollback: %s %+v", e.Result, e.Transaction)
+	}
+}
+
+// chanSink 解析协程中输出的事件送入通道
+type chanSink chan *event.Event
+
+func (s chanSink) Emit(e *event.Event) {
+	s <- e
+}
+
+func TestRunIdleWithoutPackets(t *testing.T) {
+	sink := make(chanSink, 10)
+	stm := &Stream{logger: logrus.New(), sink: sink, clock: &clock{}, idleTx: time.Second,
+		Packet: make(chan *Packet), secure: newSecure()}
+	// 实时抓包时事务开启后客户端不再发包，只有 ticker 按本地时间检查
+	e := &event.Event{Statement: "BEGIN", Timestamp: time.Now().Add(-time.Second), Latency: time.Millisecond}
+	stm.trackTransaction(&command{event: e}, &Response{HasStatus: true, Status: SERVER_STATUS_IN_TRANS})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stm.run()
+	}()
+	select {
+	case e := <-sink:
+		if e.Alert != event.AlertIdleTransaction {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no idle alert without packets")
+	}
+	close(stm.Packet)
+	<-done
+	if e := <-sink; e.Transaction == nil || e.Transaction.Outcome != txDisconnected {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"github.com/JacksonChan-X/sql-sniffer/decoder"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	decoder.Register(Decoder{})
+}
+
+// Decoder 注册到 decoder 包的 Redis 解析器
+type Decoder struct{}
+
+func (Decoder) Name() string {
+	return event.ProtocolRedis
+}
+
+func (Decoder) DefaultPorts() []string {
+	return []string{"6379"}
+}
+
+func (Decoder) Flags(fs *pflag.FlagSet) {}
+
+func (Decoder) NewFactory(opts decoder.Options) decoder.StreamFactory {
+	return NewRedisStreamFactory(opts.Port, opts.Logger, opts.Sink)
+}
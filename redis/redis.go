@@ -4,9 +4,15 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/event"
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+	"github.com/JacksonChan-X/sql-sniffer/tlsdecrypt"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
@@ -16,63 +22,148 @@ import (
 
 type RedisStreamFactory struct {
 	Logger *logrus.Logger
+	Sink   event.Sink
 	Port   string
+	redis  *Redis
+	wg     sync.WaitGroup
 }
 
 type RedisStream struct {
 	net, transport gopacket.Flow
-	buf            tcpreader.ReaderStream
+	buf            *helper.TimedStream
 }
 
 type Redis struct {
 	port   string
 	logger *logrus.Logger
+	sink   event.Sink
+	mutex  sync.Mutex
+	conns  map[string]*conn
 }
 
-var (
-	redis *Redis
-	once  sync.Once
-)
+// conn 一条连接两个方向共享的状态
+type conn struct {
+	tls      *tlsdecrypt.Conn
+	refs     int       // 仍在读取该连接的方向数
+	authUser string    // 最近一次 AUTH 的用户
+	authTime time.Time // 最近一次 AUTH 的抓包时间
+}
 
-func NewInstance(port string, logger *logrus.Logger) *Redis {
-	once.Do(func() {
-		redis = &Redis{
-			port:   port,
-			logger: logger,
-		}
-	})
-	return redis
+func NewInstance(port string, logger *logrus.Logger, sink event.Sink) *Redis {
+	return &Redis{
+		port:   port,
+		logger: logger,
+		sink:   sink,
+		conns:  make(map[string]*conn),
+	}
+}
+
+func NewRedisStreamFactory(port string, logger *logrus.Logger, sink event.Sink) *RedisStreamFactory {
+	return &RedisStreamFactory{
+		Logger: logger,
+		Sink:   sink,
+		Port:   port,
+		redis:  NewInstance(port, logger, sink),
+	}
 }
 
 func (p *RedisStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
 	ps := &RedisStream{
 		net:       net,
 		transport: transport,
-		buf:       tcpreader.NewReaderStream(),
+		buf:       helper.NewTimedStream(),
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.redis.ResolveStream(net, transport, ps.buf)
+	}()
+
+	return ps.buf
+}
+
+// Wait 等待所有流的解析协程退出，需在 assembler FlushAll 之后调用
+func (p *RedisStreamFactory) Wait() {
+	p.wg.Wait()
+}
+
+// acquire 返回连接两个方向共享的状态，读完后调用 release
+func (m *Redis) acquire(streamID string) *conn {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c, ok := m.conns[streamID]
+	if !ok {
+		c = &conn{tls: tlsdecrypt.NewConn()}
+		m.conns[streamID] = c
 	}
+	c.refs++
+	return c
+}
 
-	redisInstance := NewInstance(p.Port, p.Logger)
-	go redisInstance.ResolveStream(net, transport, &ps.buf)
+func (m *Redis) release(streamID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	return &ps.buf
+	if c := m.conns[streamID]; c != nil {
+		c.refs--
+		if c.refs == 0 {
+			delete(m.conns, streamID)
+		}
+	}
 }
 
-func (m *Redis) ResolveStream(net, transport gopacket.Flow, r io.Reader) {
+func (m *Redis) ResolveStream(net, transport gopacket.Flow, raw *helper.TimedStream) {
+	streamID := fmt.Sprintf("%v:%v", net.FastHash(), transport.FastHash())
+	clientAddr, serverAddr := helper.FlowAddr(net, transport)
+	if transport.Src().String() == m.port {
+		// 回复方向的源地址是服务端
+		clientAddr, serverAddr = serverAddr, clientAddr
+	}
+	c := m.acquire(streamID)
+	defer m.release(streamID)
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error(fmt.Sprintf("ERR : redis stream panic, stream:%s,err:%v\n%s", streamID, err, debug.Stack()))
+			// 丢弃剩余数据，避免阻塞 assembler
+			tcpreader.DiscardBytesToEOF(raw)
+		}
+	}()
+
+	// 两个方向的第一个记录分别是 ClientHello 和 ServerHello
+	r, encrypted, _ := tlsdecrypt.Sniff(raw, c.tls, transport.Dst().String() == m.port)
+	if encrypted && transport.Dst().String() == m.port {
+		m.logger.Info(fmt.Sprintf("stream:%s use TLS", streamID))
+	}
 	buf := bufio.NewReader(r)
+	if transport.Src().String() == m.port {
+		m.resolveReplies(streamID, clientAddr, serverAddr, c, r, buf, raw, encrypted)
+		return
+	}
 	var cmd string
 	var cmdCount = 0
 	for {
+		// bufio 会预读，减去缓冲中未消费的部分才是本行首字节的位置
+		start := r.Offset() - int64(buf.Buffered())
 		line, _, err := buf.ReadLine()
 		if err != nil {
 			if err == io.EOF {
 				m.logger.Info("redis stream end")
 				return
 			}
-			m.logger.Error(fmt.Sprintf("redis stream read line error: %v", err))
-			continue
+			if encrypted {
+				m.logger.Warn(fmt.Sprintf("ERR : Could not decrypt TLS, stream:%s,err:%s", streamID, err))
+			} else {
+				m.logger.Error(fmt.Sprintf("redis stream read line error: %v", err))
+			}
+			// 读取错误不会恢复，丢弃剩余数据，避免阻塞 assembler
+			tcpreader.DiscardBytesToEOF(raw)
+			return
 		}
+		ts := r.Seen(start)
 
-		if len(line) == 0 || transport.Src().String() == m.port {
+		if len(line) == 0 {
 			continue
 		}
 
@@ -81,6 +172,9 @@ func (m *Redis) ResolveStream(net, transport gopacket.Flow, r io.Reader) {
 		// 处理RESP协议
 		if strings.HasPrefix(string(line), "*") {
 			count, err := strconv.Atoi(string(line[1:]))
+			if err == nil && count < 0 {
+				err = ErrMalformReply
+			}
 			if err != nil {
 				m.logger.Error(fmt.Sprintf("parse command count error: %v, line: %s", err, string(line)))
 				continue
@@ -88,7 +182,8 @@ func (m *Redis) ResolveStream(net, transport gopacket.Flow, r io.Reader) {
 
 			cmdCount = count
 			cmd = ""
-			cmdParts := make([]string, 0, cmdCount)
+			// 参数个数来自线上，只按上限预分配
+			cmdParts := make([]string, 0, min(cmdCount, maxPreallocParts))
 
 			for j := 0; j < cmdCount; j++ {
 				// 读取长度行 ($n)
@@ -114,8 +209,21 @@ func (m *Redis) ResolveStream(net, transport gopacket.Flow, r io.Reader) {
 			}
 
 			if len(cmdParts) > 0 {
-				cmd = strings.Join(cmdParts, " ")
-				m.logger.Info(fmt.Sprintf("Command: %s", cmd))
+				if user, ok := authUser(cmdParts); ok {
+					m.mutex.Lock()
+					c.authUser, c.authTime = user, ts
+					m.mutex.Unlock()
+				}
+				cmd = strings.Join(maskAuth(cmdParts), " ")
+				m.sink.Emit(&event.Event{
+					Protocol:   event.ProtocolRedis,
+					ClientAddr: clientAddr,
+					ServerAddr: serverAddr,
+					ConnID:     streamID,
+					Command:    strings.ToUpper(cmdParts[0]),
+					Statement:  cmd,
+					Timestamp:  ts,
+				})
 			}
 		}
 	}
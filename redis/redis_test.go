@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/authfail"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/sirupsen/logrus"
+)
+
+type sliceSink []*event.Event
+
+func (s *sliceSink) Emit(e *event.Event) {
+	*s = append(*s, e)
+}
+
+func TestReplyDirectionAddr(t *testing.T) {
+	authfail.SetLimit(2, time.Minute)
+	defer authfail.SetLimit(0, 0)
+
+	var sink sliceSink
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	m := NewInstance("6379", logger, &sink)
+
+	// 服务端 -> 客户端方向的流
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, net.IPv4(10, 0, 0, 3).To4(), net.IPv4(10, 0, 0, 8).To4())
+	transport := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0x18, 0xeb}, []byte{0xc3, 0x50}) // 6379 -> 50000
+	buf := helper.NewTimedStream()
+	go func() {
+		buf.Reassembled([]tcpassembly.Reassembly{{
+			Bytes: []byte(strings.Repeat("-WRONGPASS invalid username-password pair or user is disabled.\r\n", 2)),
+			Seen:  time.Now(),
+		}})
+		buf.ReassemblyComplete()
+	}()
+	m.ResolveStream(netFlow, transport, buf)
+
+	if len(sink) != 3 || sink[2].Alert != event.AlertBruteForce {
+		t.Fatalf("unexpected events: %+v", sink)
+	}
+	for _, e := range sink {
+		if e.ClientAddr != "10.0.0.8:50000" || e.ServerAddr != "10.0.0.3:6379" {
+			t.Fatalf("unexpected addr: client %s, server %s", e.ClientAddr, e.ServerAddr)
+		}
+	}
+}
+
+func TestReadReplyLength(t *testing.T) {
+	for _, c := range []struct {
+		reply string
+		msg   string
+		err   error
+	}{
+		{"$-1\r\n", "", nil},
+		{"$-2\r\n", "", ErrMalformReply},
+		{"$9999999999999999999\r\n", "", ErrMalformReply},
+		{"$536870913\r\n", "", ErrMalformReply},
+		{"$1000\r\nshort", "", io.ErrUnexpectedEOF},
+		{"!5000\r\n" + strings.Repeat("E", 5000) + "\r\n", strings.Repeat("E", maxErrorLength), nil},
+	} {
+		msg, err := readReply(bufio.NewReader(strings.NewReader(c.reply)), 0)
+		if msg != c.msg || err != c.err {
+			t.Fatalf("%.20q: unexpected %.20q %v", c.reply, msg, err)
+		}
+	}
+}
+
+func TestMaskAuth(t *testing.T) {
+	for _, c := range []struct{ cmd, want string }{
+		{"AUTH secret", "AUTH ******"},
+		{"auth app secret", "auth app ******"},
+		{"HELLO 3 AUTH app secret SETNAME web", "HELLO 3 AUTH app ****** SETNAME web"},
+		{"GET key", "GET key"},
+	} {
+		parts := strings.Fields(c.cmd)
+		if got := strings.Join(maskAuth(parts), " "); got != c.want || strings.Join(parts, " ") != c.cmd {
+			t.Fatalf("unexpected mask %q: %q", c.cmd, got)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/JacksonChan-X/sql-sniffer/authfail"
+	"github.com/JacksonChan-X/sql-sniffer/event"
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+
+	"github.com/google/gopacket/tcpassembly/tcpreader"
+)
+
+const (
+	maxReplyDepth    = 32                // 嵌套回复的最大层数
+	maxBulkLength    = 512 * 1024 * 1024 // 服务端默认的 proto-max-bulk-len
+	maxErrorLength   = 4096              // 错误回复最多保留的字节数，其余丢弃
+	maxPreallocParts = 64                // 命令参数按个数预分配的上限
+)
+
+var ErrMalformReply = errors.New("MALFORM_REPLY")
+
+// authFailures AUTH 和 HELLO ... AUTH 密码错误时的回复
+var authFailures = []string{
+	"WRONGPASS",
+	"ERR invalid password",
+	"ERR invalid username-password pair",
+}
+
+// maskAuth 返回隐藏了 AUTH 和 HELLO ... AUTH 中密码的参数，不修改 parts
+func maskAuth(parts []string) []string {
+	var password int
+	switch strings.ToUpper(parts[0]) {
+	case "AUTH":
+		password = len(parts) - 1
+	case "HELLO":
+		for i := 1; i+2 < len(parts); i++ {
+			if strings.EqualFold(parts[i], "AUTH") {
+				password = i + 2
+				break
+			}
+		}
+	}
+	if password == 0 {
+		return parts
+	}
+	masked := append([]string(nil), parts...)
+	masked[password] = "******"
+	return masked
+}
+
+// authUser 返回 AUTH [user] password 或 HELLO ver AUTH user password 中的用户
+func authUser(parts []string) (string, bool) {
+	switch strings.ToUpper(parts[0]) {
+	case "AUTH":
+		if len(parts) == 3 {
+			return parts[1], true
+		}
+		return "default", len(parts) == 2
+	case "HELLO":
+		for i := 1; i+2 < len(parts); i++ {
+			if strings.EqualFold(parts[i], "AUTH") {
+				return parts[i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// resolveReplies 解析服务端回复，只处理认证失败
+func (m *Redis) resolveReplies(streamID, clientAddr, serverAddr string, c *conn, r helper.Stream, buf *bufio.Reader, raw *helper.TimedStream, encrypted bool) {
+	for {
+		start := r.Offset() - int64(buf.Buffered())
+		msg, err := readReply(buf, 0)
+		if err == ErrMalformReply {
+			// 抓包从回复中间开始，跳过这一行继续
+			m.logger.Debug(fmt.Sprintf("skip malformed redis reply, stream:%s", streamID))
+			continue
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if encrypted {
+				m.logger.Warn(fmt.Sprintf("ERR : Could not decrypt TLS, stream:%s,err:%s", streamID, err))
+			} else {
+				m.logger.Error(fmt.Sprintf("redis stream read reply error: %v", err))
+			}
+			tcpreader.DiscardBytesToEOF(raw)
+			return
+		}
+		if !isAuthFailure(msg) {
+			continue
+		}
+
+		ts := r.Seen(start)
+		m.mutex.Lock()
+		user, authTime := c.authUser, c.authTime
+		m.mutex.Unlock()
+		e := &event.Event{
+			Protocol:     event.ProtocolRedis,
+			ClientAddr:   clientAddr,
+			ServerAddr:   serverAddr,
+			ConnID:       streamID,
+			User:         user,
+			Command:      "AUTH",
+			Statement:    fmt.Sprintf("Authenticate user:%s failed", user),
+			Timestamp:    ts,
+			Result:       event.ResultError,
+			ErrorMessage: msg,
+		}
+		if !authTime.IsZero() && !authTime.After(ts) {
+			e.Timestamp, e.Latency = authTime, ts.Sub(authTime)
+		}
+		m.sink.Emit(e)
+		for _, alert := range authfail.Observe(e) {
+			m.sink.Emit(alert)
+		}
+	}
+}
+
+func isAuthFailure(msg string) bool {
+	for _, prefix := range authFailures {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readReply 读取一个完整的 RESP2/RESP3 回复，回复是错误时返回错误信息
+func readReply(buf *bufio.Reader, depth int) (string, error) {
+	if depth > maxReplyDepth {
+		return "", ErrMalformReply
+	}
+	line, err := readLine(buf)
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 {
+		return "", ErrMalformReply
+	}
+	switch line[0] {
+	case '-':
+		return line[1:], nil
+	case '+', ':', ',', '#', '_', '(':
+		return "", nil
+	case '$', '!', '=':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxBulkLength {
+			return "", ErrMalformReply
+		}
+		if n == -1 {
+			return "", nil
+		}
+		if line[0] == '!' {
+			// 长度来自线上，只保留前 maxErrorLength 字节
+			data := make([]byte, min(n, maxErrorLength))
+			if _, err := io.ReadFull(buf, data); err != nil {
+				return "", unexpected(err)
+			}
+			if _, err := io.CopyN(io.Discard, buf, int64(n-len(data)+2)); err != nil {
+				return "", unexpected(err)
+			}
+			return string(data), nil
+		}
+		if _, err := io.CopyN(io.Discard, buf, int64(n+2)); err != nil {
+			return "", unexpected(err)
+		}
+		return "", nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", ErrMalformReply
+		}
+		if line[0] == '%' || line[0] == '|' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			if _, err := readReply(buf, depth+1); err != nil {
+				return "", unexpected(err)
+			}
+		}
+		if line[0] == '|' {
+			// 属性之后是真正的回复
+			return readReply(buf, depth+1)
+		}
+		return "", nil
+	}
+	return "", ErrMalformReply
+}
+
+// readLine 读取以 \r\n 结尾的一行，不包括行尾
+func readLine(buf *bufio.Reader) (string, error) {
+	line, err := buf.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(line[:len(line)-1], "\r"), nil
+}
+
+// unexpected 回复中间遇到的 EOF 不是正常结束
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
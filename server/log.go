@@ -5,18 +5,20 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// 抓包时间戳需要区分同一秒内的多条语句
+const timestampFormat = "2006-01-02 15:04:05.000000"
+
 func NewLogger(debug bool) *logrus.Logger {
 	logger := logrus.New()
 	logger.SetOutput(os.Stdout)
 	logger.SetReportCaller(true) // 启用调用源信息
 
 	formatter := &logrus.TextFormatter{
-		TimestampFormat:  time.DateTime,      // 时间格式化为 "2006-01-02 15:04:05"
+		TimestampFormat:  timestampFormat,    // 抓包时间精确到微秒
 		FullTimestamp:    true,               // 显示完整时间
 		CallerPrettyfier: formatCallerSource, // 自定义调用源格式
 	}
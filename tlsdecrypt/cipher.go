@@ -0,0 +1,272 @@
+package tlsdecrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"hash"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// cipherSuite 解密需要的密码套件参数
+type cipherSuite struct {
+	keyLen        int
+	ivLen         int // key block 中每个方向的 IV 长度
+	macLen        int // CBC 套件的 MAC 长度，AEAD 套件为 0
+	hash          func() hash.Hash
+	aead          func(key []byte) (cipher.AEAD, error)
+	explicitNonce bool // TLS 1.2 的 GCM 在每条记录前带 8 字节 nonce
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	suiteAES128GCM       = &cipherSuite{keyLen: 16, ivLen: 4, hash: sha256.New, aead: aesGCM, explicitNonce: true}
+	suiteAES256GCM       = &cipherSuite{keyLen: 32, ivLen: 4, hash: sha512.New384, aead: aesGCM, explicitNonce: true}
+	suiteChaCha20        = &cipherSuite{keyLen: 32, ivLen: 12, hash: sha256.New, aead: chacha20poly1305.New}
+	suiteAES128CBCSHA    = &cipherSuite{keyLen: 16, ivLen: 16, macLen: sha1.Size, hash: sha256.New}
+	suiteAES256CBCSHA    = &cipherSuite{keyLen: 32, ivLen: 16, macLen: sha1.Size, hash: sha256.New}
+	suiteAES128CBCSHA256 = &cipherSuite{keyLen: 16, ivLen: 16, macLen: sha256.Size, hash: sha256.New}
+	suiteAES256CBCSHA256 = &cipherSuite{keyLen: 32, ivLen: 16, macLen: sha256.Size, hash: sha256.New}
+	suiteAES256CBCSHA384 = &cipherSuite{keyLen: 32, ivLen: 16, macLen: sha512.Size384, hash: sha512.New384}
+
+	suiteTLS13AES128GCM = &cipherSuite{keyLen: 16, ivLen: 12, hash: sha256.New, aead: aesGCM}
+	suiteTLS13AES256GCM = &cipherSuite{keyLen: 32, ivLen: 12, hash: sha512.New384, aead: aesGCM}
+	suiteTLS13ChaCha20  = &cipherSuite{keyLen: 32, ivLen: 12, hash: sha256.New, aead: chacha20poly1305.New}
+)
+
+// cipherSuites 支持解密的套件，key 为 IANA 编号
+var cipherSuites = map[uint16]*cipherSuite{
+	tls.TLS_AES_128_GCM_SHA256:       suiteTLS13AES128GCM,
+	tls.TLS_AES_256_GCM_SHA384:       suiteTLS13AES256GCM,
+	tls.TLS_CHACHA20_POLY1305_SHA256: suiteTLS13ChaCha20,
+
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   suiteAES128GCM,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: suiteAES128GCM,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         suiteAES128GCM,
+	0x009e:                                      suiteAES128GCM, // TLS_DHE_RSA_WITH_AES_128_GCM_SHA256
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   suiteAES256GCM,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: suiteAES256GCM,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         suiteAES256GCM,
+	0x009f:                                      suiteAES256GCM, // TLS_DHE_RSA_WITH_AES_256_GCM_SHA384
+
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:   suiteChaCha20,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256: suiteChaCha20,
+	0xccaa: suiteChaCha20, // TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:         suiteAES128CBCSHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:   suiteAES128CBCSHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA: suiteAES128CBCSHA,
+	0x0033:                                   suiteAES128CBCSHA, // TLS_DHE_RSA_WITH_AES_128_CBC_SHA
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:         suiteAES256CBCSHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:   suiteAES256CBCSHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA: suiteAES256CBCSHA,
+	0x0039:                                   suiteAES256CBCSHA, // TLS_DHE_RSA_WITH_AES_256_CBC_SHA
+
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         suiteAES128CBCSHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   suiteAES128CBCSHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: suiteAES128CBCSHA256,
+	0x0067: suiteAES128CBCSHA256, // TLS_DHE_RSA_WITH_AES_128_CBC_SHA256
+	0x003d: suiteAES256CBCSHA256, // TLS_RSA_WITH_AES_256_CBC_SHA256
+	0x006b: suiteAES256CBCSHA256, // TLS_DHE_RSA_WITH_AES_256_CBC_SHA256
+	0xc028: suiteAES256CBCSHA384, // TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384
+	0xc024: suiteAES256CBCSHA384, // TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384
+}
+
+// recordCipher 解密一个方向的记录
+type recordCipher interface {
+	// decrypt 返回记录的真实类型和明文，header 为 5 字节记录头
+	decrypt(header, payload []byte, seq uint64) (byte, []byte, error)
+}
+
+// newCipher12 生成 TLS 1.0 ~ 1.2 的解密器，key、iv 取自 key block
+func (s *cipherSuite) newCipher12(version uint16, key, iv []byte, etm bool) (recordCipher, error) {
+	if s.aead != nil {
+		aead, err := s.aead(key)
+		if err != nil {
+			return nil, err
+		}
+		return &aeadCipher{aead: aead, iv: iv, explicitNonce: s.explicitNonce}, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &cbcCipher{block: block, macLen: s.macLen, etm: etm}
+	if version == tls.VersionTLS10 {
+		c.iv = iv
+	}
+	return c, nil
+}
+
+// newCipher13 由流量密钥生成 TLS 1.3 的解密器
+func (s *cipherSuite) newCipher13(secret []byte) (recordCipher, error) {
+	key := expandLabel(s.hash, secret, "key", s.keyLen)
+	iv := expandLabel(s.hash, secret, "iv", s.ivLen)
+	aead, err := s.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aeadCipher{aead: aead, iv: iv, tls13: true}, nil
+}
+
+// nextSecret KeyUpdate 之后的流量密钥
+func (s *cipherSuite) nextSecret(secret []byte) []byte {
+	return expandLabel(s.hash, secret, "traffic upd", len(secret))
+}
+
+type aeadCipher struct {
+	aead          cipher.AEAD
+	iv            []byte // GCM 为 4 字节固定部分，ChaCha20 和 TLS 1.3 为 12 字节
+	explicitNonce bool
+	tls13         bool
+}
+
+func (c *aeadCipher) decrypt(header, payload []byte, seq uint64) (byte, []byte, error) {
+	var nonce []byte
+	if c.explicitNonce {
+		if len(payload) < 8 {
+			return 0, nil, ErrDecrypt
+		}
+		nonce = append(append([]byte(nil), c.iv...), payload[:8]...)
+		payload = payload[8:]
+	} else {
+		nonce = append([]byte(nil), c.iv...)
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+		}
+	}
+	if len(payload) < c.aead.Overhead() {
+		return 0, nil, ErrDecrypt
+	}
+
+	ad := header
+	if !c.tls13 {
+		ad = make([]byte, 13)
+		binary.BigEndian.PutUint64(ad, seq)
+		copy(ad[8:11], header[:3])
+		binary.BigEndian.PutUint16(ad[11:], uint16(len(payload)-c.aead.Overhead()))
+	}
+	plain, err := c.aead.Open(nil, nonce, payload, ad)
+	if err != nil {
+		return 0, nil, ErrDecrypt
+	}
+	if !c.tls13 {
+		return header[0], plain, nil
+	}
+
+	// TLSInnerPlaintext: 内容 + 真实类型 + 填充的 0
+	i := len(plain) - 1
+	for i >= 0 && plain[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, ErrDecrypt
+	}
+	return plain[i], plain[:i], nil
+}
+
+type cbcCipher struct {
+	block  cipher.Block
+	macLen int
+	iv     []byte // TLS 1.0 没有显式 IV，使用上一条记录的最后一个密文块
+	etm    bool   // encrypt_then_mac 扩展，MAC 在密文之后
+}
+
+// decrypt 只解密不校验 MAC
+func (c *cbcCipher) decrypt(header, payload []byte, seq uint64) (byte, []byte, error) {
+	bs := c.block.BlockSize()
+	if c.etm {
+		if len(payload) < c.macLen {
+			return 0, nil, ErrDecrypt
+		}
+		payload = payload[:len(payload)-c.macLen]
+	}
+	iv := c.iv
+	if iv == nil {
+		if len(payload) < bs {
+			return 0, nil, ErrDecrypt
+		}
+		iv, payload = payload[:bs], payload[bs:]
+	}
+	if len(payload) == 0 || len(payload)%bs != 0 {
+		return 0, nil, ErrDecrypt
+	}
+	plain := make([]byte, len(payload))
+	cipher.NewCBCDecrypter(c.block, iv).CryptBlocks(plain, payload)
+	if c.iv != nil {
+		c.iv = append([]byte(nil), payload[len(payload)-bs:]...)
+	}
+
+	padding := int(plain[len(plain)-1]) + 1
+	if padding > len(plain) {
+		return 0, nil, ErrDecrypt
+	}
+	plain = plain[:len(plain)-padding]
+	if !c.etm {
+		if len(plain) < c.macLen {
+			return 0, nil, ErrDecrypt
+		}
+		plain = plain[:len(plain)-c.macLen]
+	}
+	return header[0], plain, nil
+}
+
+// prf TLS 1.2 使用套件的哈希，TLS 1.0/1.1 使用 MD5 和 SHA1 的组合
+func prf(version uint16, h func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	if version >= tls.VersionTLS12 {
+		return pHash(h, secret, labelSeed, n)
+	}
+	half := (len(secret) + 1) / 2
+	out := pHash(md5.New, secret[:half], labelSeed, n)
+	for i, b := range pHash(sha1.New, secret[len(secret)-half:], labelSeed, n) {
+		out[i] ^= b
+	}
+	return out
+}
+
+// pHash RFC 5246 5. P_hash
+func pHash(h func() hash.Hash, secret, seed []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	mac := hmac.New(h, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
+
+// expandLabel RFC 8446 7.1. HKDF-Expand-Label
+func expandLabel(h func() hash.Hash, secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(n))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0) // context 为空
+	out := make([]byte, n)
+	hkdf.Expand(h, secret, info).Read(out)
+	return out
+}
@@ -0,0 +1,176 @@
+package tlsdecrypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrMalformRecord     = errors.New("TLS RECORD INVALID")
+	ErrNoHandshake       = errors.New("TLS HANDSHAKE NOT CAPTURED")
+	ErrNoKey             = errors.New("TLS KEY NOT FOUND")
+	ErrUnsupportedCipher = errors.New("TLS CIPHER SUITE UNSUPPORTED")
+	ErrDecrypt           = errors.New("TLS DECRYPT FAILED")
+)
+
+// 握手消息中用到的扩展
+const (
+	extEncryptThenMAC    uint16 = 0x0016
+	extSupportedVersions uint16 = 0x002b
+)
+
+// helloRetryRequest ServerHello.random 为该值时是 HelloRetryRequest
+var helloRetryRequest = sha256.Sum256([]byte("HelloRetryRequest"))
+
+// Conn 一条 TLS 连接两个方向共享的握手信息，
+// 客户端方向解析 ClientHello，服务端方向解析 ServerHello
+type Conn struct {
+	mutex        sync.Mutex
+	clientRandom []byte
+	serverRandom []byte
+	version      uint16
+	suiteID      uint16
+	suite        *cipherSuite
+	etm          bool
+}
+
+func NewConn() *Conn {
+	return &Conn{}
+}
+
+// String 返回协商的版本和套件，如 TLS 1.3 TLS_AES_128_GCM_SHA256，未抓到握手时返回 TLS
+func (c *Conn) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.version == 0 {
+		return "TLS"
+	}
+	return tls.VersionName(c.version) + " " + tls.CipherSuiteName(c.suiteID)
+}
+
+// Version 返回协商的版本，未抓到 ServerHello 时为 0
+func (c *Conn) Version() uint16 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.version
+}
+
+// clientHello 记录 ClientHello.random
+func (c *Conn) clientHello(body []byte) error {
+	if len(body) < 34 {
+		return ErrMalformRecord
+	}
+	c.mutex.Lock()
+	c.clientRandom = append([]byte(nil), body[2:34]...)
+	c.mutex.Unlock()
+	return nil
+}
+
+// serverHello 记录 ServerHello.random、协商的版本和套件
+func (c *Conn) serverHello(body []byte) error {
+	if len(body) < 38 {
+		return ErrMalformRecord
+	}
+	version := binary.BigEndian.Uint16(body[0:2])
+	random := body[2:34]
+	if bytes.Equal(random, helloRetryRequest[:]) {
+		// 客户端会重发 ClientHello，等待下一个 ServerHello
+		return nil
+	}
+	data := body[34:]
+	sessionIDLen := int(data[0])
+	if len(data) < 1+sessionIDLen+3 {
+		return ErrMalformRecord
+	}
+	data = data[1+sessionIDLen:]
+	suiteID := binary.BigEndian.Uint16(data[0:2])
+	data = data[3:] // compression_method
+
+	etm := false
+	if len(data) >= 2 {
+		extLen := int(binary.BigEndian.Uint16(data[0:2]))
+		data = data[2:]
+		if extLen < len(data) {
+			data = data[:extLen]
+		}
+		for len(data) >= 4 {
+			typ := binary.BigEndian.Uint16(data[0:2])
+			size := int(binary.BigEndian.Uint16(data[2:4]))
+			if 4+size > len(data) {
+				break
+			}
+			switch ext := data[4 : 4+size]; typ {
+			case extSupportedVersions:
+				if size == 2 {
+					version = binary.BigEndian.Uint16(ext)
+				}
+			case extEncryptThenMAC:
+				etm = true
+			}
+			data = data[4+size:]
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.serverRandom = append([]byte(nil), random...)
+	c.version = version
+	c.suiteID = suiteID
+	c.suite = cipherSuites[suiteID]
+	c.etm = etm
+	return nil
+}
+
+// cipher12 由 master secret 生成 TLS 1.0 ~ 1.2 一个方向的解密器
+func (c *Conn) cipher12(client bool) (recordCipher, error) {
+	c.mutex.Lock()
+	version, suite, etm := c.version, c.suite, c.etm
+	clientRandom, serverRandom := c.clientRandom, c.serverRandom
+	c.mutex.Unlock()
+
+	switch {
+	case version == 0 || clientRandom == nil:
+		return nil, ErrNoHandshake
+	case suite == nil || version >= tls.VersionTLS13:
+		return nil, ErrUnsupportedCipher
+	}
+	master := lookupSecret(labelClientRandom, clientRandom)
+	if master == nil {
+		return nil, ErrNoKey
+	}
+
+	// key_block: client_write_MAC, server_write_MAC, client_write_key, server_write_key, client_write_IV, server_write_IV
+	seed := append(append([]byte(nil), serverRandom...), clientRandom...)
+	block := prf(version, suite.hash, master, "key expansion", seed, 2*(suite.macLen+suite.keyLen+suite.ivLen))
+	block = block[2*suite.macLen:]
+	key, iv := block[:suite.keyLen], block[2*suite.keyLen:2*suite.keyLen+suite.ivLen]
+	if !client {
+		key = block[suite.keyLen : 2*suite.keyLen]
+		iv = block[2*suite.keyLen+suite.ivLen:]
+	}
+	return suite.newCipher12(version, key, iv, etm)
+}
+
+// secret13 查找 TLS 1.3 的流量密钥
+func (c *Conn) secret13(label string) (*cipherSuite, []byte, error) {
+	c.mutex.Lock()
+	suite, clientRandom := c.suite, c.clientRandom
+	c.mutex.Unlock()
+
+	if clientRandom == nil {
+		return nil, nil, ErrNoHandshake
+	}
+	if suite == nil {
+		return nil, nil, ErrUnsupportedCipher
+	}
+	secret := lookupSecret(label, clientRandom)
+	if secret == nil {
+		return nil, nil, ErrNoKey
+	}
+	return suite, secret, nil
+}
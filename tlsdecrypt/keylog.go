@@ -0,0 +1,121 @@
+// Package tlsdecrypt 使用 NSS 格式的密钥日志(SSLKEYLOGFILE)解密抓到的 TLS 1.0 ~ 1.3 流量
+package tlsdecrypt
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 密钥日志中的标签
+const (
+	labelClientRandom            = "CLIENT_RANDOM" // TLS 1.2 及以下的 master secret
+	labelClientHandshakeSecret   = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
+	labelServerHandshakeSecret   = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
+	labelClientApplicationSecret = "CLIENT_TRAFFIC_SECRET_0"
+	labelServerApplicationSecret = "SERVER_TRAFFIC_SECRET_0"
+)
+
+const (
+	// keyWait 密钥日志仍在被写入时，等待客户端写入新密钥的最长时间
+	keyWait = time.Second
+	// activeWindow 密钥日志在该时间内被修改过，认为仍有客户端在写入
+	activeWindow = time.Minute
+)
+
+// KeyLog 密钥日志，找不到密钥时重新读取文件，实时抓包时客户端会不断追加
+type KeyLog struct {
+	path    string
+	mutex   sync.Mutex
+	secrets map[string][]byte // 标签 + client random -> secret
+	modTime time.Time
+	size    int64
+}
+
+var keyLog *KeyLog
+
+// LoadKeyLog 读取密钥日志，之后的 TLS 连接都用它解密
+func LoadKeyLog(path string) error {
+	k := &KeyLog{path: path, secrets: make(map[string][]byte)}
+	if err := k.reload(); err != nil {
+		return err
+	}
+	keyLog = k
+	return nil
+}
+
+// reload 文件有变化时重新读取
+func (k *KeyLog) reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return nil
+	}
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		random, err := hex.DecodeString(fields[1])
+		if err != nil || len(random) != 32 {
+			continue
+		}
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		k.secrets[fields[0]+string(random)] = secret
+	}
+	k.modTime, k.size = info.ModTime(), info.Size()
+	return scanner.Err()
+}
+
+// lookup 查找连接的密钥，文件最近被修改过时等待客户端写入。
+// 等待时不持有锁，其他连接的查找不会被阻塞
+func (k *KeyLog) lookup(label string, random []byte) []byte {
+	key := label + string(random)
+	deadline := time.Now().Add(keyWait)
+	for {
+		secret, wait := k.find(key)
+		if secret != nil || !wait || time.Now().After(deadline) {
+			return secret
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// find 查找密钥，找不到时重新读取文件，返回的 wait 表示文件仍在被写入、值得继续等待
+func (k *KeyLog) find(key string) (secret []byte, wait bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if secret, ok := k.secrets[key]; ok {
+		return secret, false
+	}
+	if k.reload() != nil {
+		return nil, false
+	}
+	if secret, ok := k.secrets[key]; ok {
+		return secret, false
+	}
+	return nil, time.Since(k.modTime) <= activeWindow
+}
+
+func lookupSecret(label string, random []byte) []byte {
+	if keyLog == nil || len(random) == 0 {
+		return nil
+	}
+	return keyLog.lookup(label, random)
+}
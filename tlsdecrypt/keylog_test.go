@@ -0,0 +1,43 @@
+package tlsdecrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyLogLookupWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keylog.txt")
+	known, missing := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	line := labelClientRandom + " " + hex.EncodeToString(known) + " " + hex.EncodeToString(bytes.Repeat([]byte{3}, 48)) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k := &KeyLog{path: path, secrets: make(map[string][]byte)}
+	if err := k.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件刚被修改过，找不到的密钥会等待 keyWait，期间其他连接的查找不被阻塞
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if secret := k.lookup(labelClientRandom, missing); secret != nil {
+			t.Errorf("unexpected secret %x", secret)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	begin := time.Now()
+	if secret := k.lookup(labelClientRandom, known); len(secret) != 48 {
+		t.Fatalf("unexpected secret %x", secret)
+	}
+	if elapsed := time.Since(begin); elapsed > keyWait/2 {
+		t.Fatalf("lookup blocked for %s", elapsed)
+	}
+	wg.Wait()
+}
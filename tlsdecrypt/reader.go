@@ -0,0 +1,241 @@
+package tlsdecrypt
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+)
+
+// 记录类型
+const (
+	recordChangeCipherSpec byte = 20
+	recordAlert            byte = 21
+	recordHandshake        byte = 22
+	recordApplicationData  byte = 23
+)
+
+// 握手消息类型
+const (
+	msgClientHello byte = 1
+	msgServerHello byte = 2
+	msgFinished    byte = 20
+	msgKeyUpdate   byte = 24
+)
+
+const (
+	recordHeaderLen = 5
+	maxRecordLen    = 16384 + 2048 // 密文记录的最大长度
+	maxHandshakeLen = 1 << 20      // 单个握手消息的最大长度，证书链一般远小于该值
+)
+
+// Reader 解密一个方向的 TLS 记录，Read 只返回应用数据。
+// 实现 helper.Stream，Offset 为已读取的明文字节数，Seen 返回明文所在记录的抓包时间
+type Reader struct {
+	src    helper.Stream
+	conn   *Conn
+	client bool
+
+	cipher      recordCipher // 为空时记录未加密
+	seq         uint64
+	suite       *cipherSuite // TLS 1.3 当前流量密钥对应的套件
+	secret      []byte       // TLS 1.3 当前流量密钥
+	application bool         // TLS 1.3 已切换为应用数据密钥
+
+	handshake []byte // 未收完整的握手消息
+	plain     []byte // 已解密、尚未读取的应用数据
+	read      int64  // 已读取的明文字节数
+	total     int64  // 已解密的明文字节数
+	marks     []mark
+}
+
+// mark 从明文第 plain 个字节开始的数据位于原始流第 raw 个字节开始的记录中
+type mark struct {
+	plain int64
+	raw   int64
+}
+
+func NewReader(src helper.Stream, conn *Conn, client bool) *Reader {
+	return &Reader{src: src, conn: conn, client: client}
+}
+
+// IsRecord 判断数据开头是否为 TLS 握手或应用数据记录头
+func IsRecord(header []byte) bool {
+	return len(header) >= 3 &&
+		(header[0] == recordHandshake || header[0] == recordApplicationData) &&
+		header[1] == 3 && header[2] <= 4
+}
+
+// Sniff 预读流开头的记录头，是 TLS 时返回解密后的流
+func Sniff(s helper.Stream, conn *Conn, client bool) (helper.Stream, bool, error) {
+	ps := helper.NewPeekStream(s)
+	header, err := ps.Peek(3)
+	if err != nil {
+		if len(header) == 0 {
+			return ps, false, err
+		}
+		return ps, false, nil
+	}
+	if !IsRecord(header) {
+		return ps, false, nil
+	}
+	return NewReader(ps, conn, client), true, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if err := r.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	r.read += int64(n)
+	return n, nil
+}
+
+// Offset 返回已经读取的明文字节数
+func (r *Reader) Offset() int64 {
+	return r.read
+}
+
+// Seen 返回第 offset 个明文字节所在记录的抓包时间，offset 需单调递增
+func (r *Reader) Seen(offset int64) time.Time {
+	i := 0
+	for i+1 < len(r.marks) && r.marks[i+1].plain <= offset {
+		i++
+	}
+	if i >= len(r.marks) {
+		return r.src.Seen(r.src.Offset())
+	}
+	r.marks = r.marks[i:]
+	return r.src.Seen(r.marks[0].raw)
+}
+
+func (r *Reader) readRecord() error {
+	start := r.src.Offset()
+	header := make([]byte, recordHeaderLen)
+	if n, err := io.ReadFull(r.src, header); err != nil {
+		if n == 0 && err == io.EOF {
+			return io.EOF
+		}
+		return ErrMalformRecord
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if header[1] != 3 || length > maxRecordLen {
+		return ErrMalformRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r.src, payload); err != nil {
+		return ErrMalformRecord
+	}
+
+	typ := header[0]
+	if typ == recordChangeCipherSpec {
+		// TLS 1.2 及以下此后的记录使用协商的密钥加密，TLS 1.3 中只是兼容用的空消息
+		if version := r.conn.Version(); version != 0 && version < tls.VersionTLS13 {
+			cipher, err := r.conn.cipher12(r.client)
+			if err != nil {
+				return err
+			}
+			r.cipher, r.seq = cipher, 0
+		}
+		return nil
+	}
+
+	data := payload
+	if r.cipher == nil && typ == recordApplicationData {
+		if r.conn.Version() != tls.VersionTLS13 {
+			return ErrNoHandshake
+		}
+		// TLS 1.3 ServerHello 之后的记录都以应用数据的类型发出
+		label := labelServerHandshakeSecret
+		if r.client {
+			label = labelClientHandshakeSecret
+		}
+		if err := r.setSecret(label); err != nil {
+			return err
+		}
+	}
+	if r.cipher != nil {
+		var err error
+		if typ, data, err = r.cipher.decrypt(header, payload, r.seq); err != nil {
+			return err
+		}
+		r.seq++
+	}
+
+	switch typ {
+	case recordHandshake:
+		return r.readHandshake(data)
+	case recordApplicationData:
+		if len(data) > 0 {
+			r.marks = append(r.marks, mark{plain: r.total, raw: start})
+			r.total += int64(len(data))
+			r.plain = data
+		}
+	}
+	return nil
+}
+
+// readHandshake 组装握手消息，记录 hello 中的随机数，TLS 1.3 中按 Finished 和 KeyUpdate 切换密钥
+func (r *Reader) readHandshake(data []byte) error {
+	r.handshake = append(r.handshake, data...)
+	for len(r.handshake) >= 4 {
+		typ := r.handshake[0]
+		length := int(r.handshake[1])<<16 | int(r.handshake[2])<<8 | int(r.handshake[3])
+		if length > maxHandshakeLen {
+			return ErrMalformRecord
+		}
+		if len(r.handshake) < 4+length {
+			return nil
+		}
+		body := r.handshake[4 : 4+length]
+		r.handshake = r.handshake[4+length:]
+
+		var err error
+		switch {
+		case typ == msgClientHello && r.client:
+			err = r.conn.clientHello(body)
+		case typ == msgServerHello && !r.client:
+			err = r.conn.serverHello(body)
+		case typ == msgFinished && r.secret != nil && !r.application:
+			label := labelServerApplicationSecret
+			if r.client {
+				label = labelClientApplicationSecret
+			}
+			r.application = true
+			err = r.setSecret(label)
+		case typ == msgKeyUpdate && r.secret != nil:
+			err = r.updateSecret(r.suite.nextSecret(r.secret))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(r.handshake) == 0 {
+		r.handshake = nil
+	}
+	return nil
+}
+
+// setSecret 从密钥日志中取 TLS 1.3 的流量密钥
+func (r *Reader) setSecret(label string) error {
+	suite, secret, err := r.conn.secret13(label)
+	if err != nil {
+		return err
+	}
+	r.suite = suite
+	return r.updateSecret(secret)
+}
+
+func (r *Reader) updateSecret(secret []byte) error {
+	cipher, err := r.suite.newCipher13(secret)
+	if err != nil {
+		return err
+	}
+	r.cipher, r.secret, r.seq = cipher, secret, 0
+	return nil
+}
@@ -0,0 +1,185 @@
+package tlsdecrypt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JacksonChan-X/sql-sniffer/helper"
+
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// chunk 抓到的一段数据
+type chunk struct {
+	client bool
+	data   []byte
+}
+
+// recorder 按写入顺序记录两个方向的数据
+type recorder struct {
+	mutex  sync.Mutex
+	chunks []chunk
+}
+
+type recordConn struct {
+	net.Conn
+	rec    *recorder
+	client bool
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.rec.mutex.Lock()
+	c.rec.chunks = append(c.rec.chunks, chunk{client: c.client, data: append([]byte(nil), p...)})
+	c.rec.mutex.Unlock()
+	return c.Conn.Write(p)
+}
+
+func newCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"mysql"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// session 建立一条 TLS 连接，客户端发送 request，服务端回复 response，返回抓到的数据
+func session(t *testing.T, cert tls.Certificate, keylog io.Writer, version, suite uint16, request, response []byte) []chunk {
+	rec := &recorder{}
+	c, s := net.Pipe()
+	client := tls.Client(&recordConn{Conn: c, rec: rec, client: true}, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         version,
+		MaxVersion:         version,
+		CipherSuites:       []uint16{suite},
+		KeyLogWriter:       keylog,
+	})
+	server := tls.Server(&recordConn{Conn: s, rec: rec}, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		MaxVersion:   version,
+		CipherSuites: []uint16{suite},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(request))
+		if _, err := io.ReadFull(server, buf); err != nil {
+			done <- err
+			return
+		}
+		_, err := server.Write(response)
+		done <- err
+	}()
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, len(response))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// 直接关闭底层连接，net.Pipe 上没有人读取 close_notify
+	c.Close()
+	s.Close()
+	return rec.chunks
+}
+
+// replay 按抓包顺序把数据交给两个方向的 Reader，返回解密出的明文
+func replay(chunks []chunk) (request, response []byte, errs [2]error) {
+	conn := NewConn()
+	streams := [2]*helper.TimedStream{helper.NewTimedStream(), helper.NewTimedStream()}
+	plains := [2][]byte{}
+	var wg sync.WaitGroup
+	for i := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plains[i], errs[i] = io.ReadAll(NewReader(streams[i], conn, i == 0))
+			io.Copy(io.Discard, streams[i])
+		}()
+	}
+	for _, c := range chunks {
+		i := 1
+		if c.client {
+			i = 0
+		}
+		streams[i].Reassembled([]tcpassembly.Reassembly{{Bytes: c.data, Seen: time.Now()}})
+	}
+	streams[0].ReassemblyComplete()
+	streams[1].ReassemblyComplete()
+	wg.Wait()
+	return plains[0], plains[1], errs
+}
+
+func TestReader(t *testing.T) {
+	cert := newCertificate(t)
+	path := filepath.Join(t.TempDir(), "sslkeylog.txt")
+	keylog, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keylog.Close()
+
+	cases := []struct {
+		name    string
+		version uint16
+		suite   uint16
+	}{
+		{"tls13-aes128gcm", tls.VersionTLS13, tls.TLS_AES_128_GCM_SHA256},
+		{"tls12-aes128gcm", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{"tls12-aes256gcm", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		{"tls12-chacha20", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		{"tls12-aes128cbc", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+		{"tls10-aes256cbc", tls.VersionTLS10, tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+	}
+	request := []byte("\x21\x00\x00\x00\x03SELECT * FROM user WHERE id = 1")
+	response := bytes.Repeat([]byte("jackson"), 5000) // 超过一条记录
+	captures := make([][]chunk, len(cases))
+	for i, tc := range cases {
+		captures[i] = session(t, cert, keylog, tc.version, tc.suite, request, response)
+	}
+
+	// 没有密钥日志时无法解密
+	if _, _, errs := replay(captures[0]); errs[0] != ErrNoKey || errs[1] != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", errs)
+	}
+
+	if err := LoadKeyLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { keyLog = nil }()
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, resp, errs := replay(captures[i])
+			if errs[0] != nil || errs[1] != nil {
+				t.Fatalf("replay: %v", errs)
+			}
+			if !bytes.Equal(req, request) || !bytes.Equal(resp, response) {
+				t.Fatalf("unexpected plaintext: %q, %d bytes", req, len(resp))
+			}
+		})
+	}
+}